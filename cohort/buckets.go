@@ -0,0 +1,117 @@
+package cohort
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bucketing assigns the orders of a customer to buckets counted from their creation and labels those buckets
+type bucketing interface {
+	// Between returns the bucket an order placed at t falls in for a customer created at start, negative if t is before the first bucket
+	Between(start, t time.Time) int
+	// Header returns the column header of the bucket at index
+	Header(index int) string
+	// DayRange returns the first and last day of the bucket at index counted from start
+	DayRange(start time.Time, index int) (int, int)
+	// end returns when the bucket at index ends for a customer created at created, open ended buckets end once they start
+	end(created time.Time, index int) time.Time
+	// expression returns a dialect expression for the bucket an order placed at the end datetime expression falls in
+	expression(dialect Dialect, start, end string) string
+}
+
+func (period Period) end(created time.Time, index int) time.Time {
+	return period.Add(created, index+1)
+}
+
+func (period Period) expression(dialect Dialect, start, end string) string {
+	return dialect.Bucket(period, start, end)
+}
+
+// Buckets are the ascending days from customer creation irregular buckets start on, the last bucket is open ended
+type Buckets []int
+
+// ParseBuckets parses comma separated bucket edges such as 0,1,7,14,30,60,90
+func ParseBuckets(value string) (Buckets, error) {
+	buckets := Buckets{}
+	for _, edge := range strings.Split(value, ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(edge))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bucket edge %q expected a number of days", edge)
+		}
+		buckets = append(buckets, day)
+	}
+	return buckets, buckets.Validate()
+}
+
+// Validate checks that edges start at customer creation and are strictly ascending
+func (buckets Buckets) Validate() error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("Bucket edges require at least one day")
+	}
+	// orders ahead of a later first edge would be left out of the buckets while still counting as first orders
+	if buckets[0] != 0 {
+		return fmt.Errorf("Bucket edges %v must start at day 0", []int(buckets))
+	}
+	for i, edge := range buckets {
+		if edge < 0 {
+			return fmt.Errorf("Invalid bucket edge %d expected a day from customer creation", edge)
+		}
+		if i > 0 && edge <= buckets[i-1] {
+			return fmt.Errorf("Bucket edges %v must be strictly ascending", []int(buckets))
+		}
+	}
+	return nil
+}
+
+// String returns the comma separated edges
+func (buckets Buckets) String() string {
+	edges := make([]string, len(buckets))
+	for i, edge := range buckets {
+		edges[i] = strconv.Itoa(edge)
+	}
+	return strings.Join(edges, ",")
+}
+
+// Between returns the index of the last edge reached by the whole days elapsed from start until t, -1 before the first edge
+func (buckets Buckets) Between(start, t time.Time) int {
+	days := Day.Between(start, t)
+	return sort.SearchInts(buckets, days+1) - 1
+}
+
+// Header labels buckets by their day range and the last bucket by its first day, such as 1-6 and 90+
+func (buckets Buckets) Header(index int) string {
+	first, last := buckets.DayRange(time.Time{}, index)
+	if last < first {
+		return fmt.Sprintf("%d+", first)
+	}
+	return fmt.Sprintf("%d-%d", first, last)
+}
+
+// DayRange returns the first and last day of the bucket at index, the last day of the open ended bucket is -1
+func (buckets Buckets) DayRange(start time.Time, index int) (int, int) {
+	if index == len(buckets)-1 {
+		return buckets[index], -1
+	}
+	return buckets[index], buckets[index+1] - 1
+}
+
+func (buckets Buckets) end(created time.Time, index int) time.Time {
+	if index == len(buckets)-1 {
+		return created.AddDate(0, 0, buckets[index])
+	}
+	return created.AddDate(0, 0, buckets[index+1])
+}
+
+func (buckets Buckets) expression(dialect Dialect, start, end string) string {
+	days := dialect.Bucket(Day, start, end)
+	cases := strings.Builder{}
+	cases.WriteString("CASE")
+	for index := len(buckets) - 1; index >= 0; index-- {
+		cases.WriteString(fmt.Sprintf(" WHEN %s >= %d THEN %d", days, buckets[index], index))
+	}
+	cases.WriteString(" ELSE -1 END")
+	return cases.String()
+}
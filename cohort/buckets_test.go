@@ -0,0 +1,62 @@
+package cohort
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuckets(t *testing.T) {
+	_, err := ParseBuckets("0,7,7")
+	assert.Error(t, err, "should fail on edges that are not ascending")
+	_, err = ParseBuckets("-1,7")
+	assert.Error(t, err, "should fail on negative edges")
+	_, err = ParseBuckets("7,30")
+	assert.Error(t, err, "should fail on edges that do not start at customer creation")
+	_, err = ParseBuckets("0,week")
+	assert.Error(t, err, "should fail on edges that are not days")
+	buckets, err := ParseBuckets("0, 1,7,14,30,60,90")
+	assert.NoError(t, err, "should parse bucket edges")
+	assert.Equal(t, Buckets{0, 1, 7, 14, 30, 60, 90}, buckets, "should parse every edge")
+	assert.Equal(t, "0,1,7,14,30,60,90", buckets.String(), "should format edges")
+
+	created := time.Date(2015, time.January, 31, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, 0, buckets.Between(created, created.Add(23*time.Hour)), "should place the day of signup in the first bucket")
+	assert.Equal(t, 1, buckets.Between(created, created.Add(24*time.Hour)), "should place the first day in its own bucket")
+	assert.Equal(t, 2, buckets.Between(created, created.AddDate(0, 0, 13)), "should place days before the next edge in the bucket")
+	assert.Equal(t, 6, buckets.Between(created, created.AddDate(0, 0, 90)), "should place the last edge in the open ended bucket")
+	assert.Equal(t, 6, buckets.Between(created, created.AddDate(1, 0, 0)), "should place later days in the open ended bucket")
+	assert.Equal(t, -1, buckets.Between(created, created.AddDate(0, 0, -1)), "should place orders days before signup ahead of the buckets")
+	assert.Equal(t, 0, Buckets{1, 7}.Between(created, created.AddDate(0, 0, 3)), "should start buckets at the first edge")
+	assert.Equal(t, -1, Buckets{1, 7}.Between(created, created), "should place days before the first edge ahead of the buckets")
+
+	assert.Equal(t, "0-0", buckets.Header(0), "should label single days")
+	assert.Equal(t, "7-13", buckets.Header(2), "should label buckets by day range")
+	assert.Equal(t, "90+", buckets.Header(6), "should label the open ended bucket by its first day")
+
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{1, "2015-01-01T10:00:00"},
+		{2, "2015-01-02T10:00:00"},
+	}, [][]interface{}{
+		{1, 1, 1, "2015-01-01T12:00:00", 5.0},
+		{2, 1, 2, "2015-01-05T10:00:00", 10.0},
+		{3, 2, 1, "2015-06-01T10:00:00", 2.5},
+	})
+	defer cleanup()
+	config := DefaultConfig()
+	config.CohortPeriod = Month
+	config.Buckets = Buckets{0, 1, 7, 90}
+	result, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts with explicit buckets")
+	assert.Equal(t, []string{"Cohort", "Customers", "0-0", "1-6", "7-89", "90+"}, result.Headers, "should label explicit buckets")
+	assert.Equal(t, []string{"01/01/2015-01/31/2015", "2 customers", "50.00% orderers (1)", "50.00% orderers (1)", "0% orderers (0)", "50.00% orderers (1)"}, result.Rows[0], "should aggregate orders by explicit buckets")
+
+	config.Buckets = Buckets{7, 1}
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on invalid buckets")
+	config.Buckets = Buckets{7, 30}
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on buckets leaving out the first days of customers")
+}
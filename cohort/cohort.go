@@ -0,0 +1,686 @@
+// Package cohort groups customers into cohorts by creation date and aggregates their orders into retention buckets
+package cohort
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Config defines how data is imported and how cohorts are computed
+type Config struct {
+	// CustomerTimestamps parses the signup datetimes of imported customers
+	CustomerTimestamps TimestampParser
+	// OrderTimestamps parses the datetimes of imported orders
+	OrderTimestamps TimestampParser
+	// Location is the reporting timezone cohort windows and order buckets are computed in, datetimes are always stored as UTC instants
+	Location *time.Location
+	// CohortPeriod is the period customers are grouped into cohorts by
+	CohortPeriod Period
+	// CohortBy defines whether customers join cohorts at their creation or at their first order
+	CohortBy CohortBy
+	// BucketPeriod is the period orders are bucketed by from customer creation
+	BucketPeriod Period
+	// Buckets are explicit days from customer creation orders are bucketed by in place of BucketPeriod when set
+	Buckets Buckets
+	// Metrics names the registered metrics output as a row per cohort in the given order
+	Metrics []string
+	// RetentionMode defines which buckets customers count as retained in
+	RetentionMode RetentionMode
+	// From and To limit computed cohorts to those starting between them, zero values leave the range open
+	From time.Time
+	To   time.Time
+	// Engine selects whether orders are aggregated in memory or by the database
+	Engine Engine
+	// Workers is the number of cohorts computed concurrently
+	Workers int
+	// BatchSize is the number of imported rows written per insert statement
+	BatchSize int
+	// Incremental upserts imported rows and reuses cohorts cached by earlier runs that no import has changed since
+	Incremental bool
+	// Schema names the tables and columns cohorts are computed from
+	Schema Schema
+	// Columns binds the fields of imported csv files to their headers
+	Columns ColumnMapping
+	// OnError defines how imports handle rows that fail validation
+	OnError ErrorMode
+	// Rejects streams the rows rejected by imports when set, import reports only count them
+	Rejects *RejectWriter
+	// ObservedUntil is when the data was extracted, buckets no customer had reached by it are censored and the zero value uses the latest order or customer
+	ObservedUntil time.Time
+	// SegmentBy names a customers column cohorts are computed separately for every value of
+	SegmentBy string
+	// CustomerFilter restricts cohorts to the customers it holds for, datetimes compared to the created column are read in Location
+	CustomerFilter Filter
+	// OrderFilter restricts the orders aggregated into buckets to those it holds for
+	OrderFilter Filter
+	// segment restricts cohorts to the customers of a single value of SegmentBy
+	segment *segment
+}
+
+// DefaultConfig returns a Config computing weekly cohorts with weekly buckets in UTC
+func DefaultConfig() Config {
+	return Config{
+		CustomerTimestamps: DefaultTimestampParser(),
+		OrderTimestamps:    DefaultTimestampParser(),
+		Location:           time.UTC,
+		CohortPeriod:       Week,
+		CohortBy:           SignupCohorts,
+		BucketPeriod:       Week,
+		Metrics:            []string{"orderers", "first_time"},
+		RetentionMode:      Bracket,
+		Engine:             MemoryEngine,
+		Workers:            1,
+		BatchSize:          100,
+		Schema:             DefaultSchema(),
+		Columns:            DefaultColumnMapping(),
+		OnError:            FailOnError,
+	}
+}
+
+// errorMode returns the configured error mode, configs created without DefaultConfig fail on the first rejected row
+func (config Config) errorMode() ErrorMode {
+	if config.OnError == "" {
+		return FailOnError
+	}
+	return config.OnError
+}
+
+// timestamps returns parser or the default parser of configs created without DefaultConfig
+func (config Config) timestamps(parser TimestampParser) TimestampParser {
+	if parser.isZero() {
+		return DefaultTimestampParser()
+	}
+	return parser
+}
+
+// cohortBy returns the configured cohort membership or signup cohorts for configs created without DefaultConfig
+func (config Config) cohortBy() CohortBy {
+	if config.CohortBy == "" {
+		return SignupCohorts
+	}
+	return config.CohortBy
+}
+
+// bucketing returns the explicit buckets of config or its bucket period
+func (config Config) bucketing() bucketing {
+	if len(config.Buckets) != 0 {
+		return config.Buckets
+	}
+	return config.BucketPeriod
+}
+
+// customerWindow returns a condition on the filtered customers created from start until end within the segment of config
+func (config Config) customerWindow(dialect Dialect, start, end time.Time) Condition {
+	conditions := []Condition{config.Schema.customerWindow(dialect, start, end), config.CustomerFilter.condition("")}
+	if config.segment != nil {
+		conditions = append(conditions, config.segment.condition())
+	}
+	return allOf(conditions...)
+}
+
+// attributes returns the customers columns segments and the customer filter read, first order cohorts read them along with every buyer
+func (config Config) attributes() []string {
+	attributes := []string{}
+	if config.SegmentBy != "" {
+		attributes = append(attributes, config.SegmentBy)
+	}
+	for _, column := range config.CustomerFilter.columns() {
+		// first order cohorts hold the id and first order of buyers in place of their customer
+		if column != config.SegmentBy && column != "id" && column != "created" {
+			attributes = append(attributes, column)
+		}
+	}
+	return attributes
+}
+
+// columns returns the configured column mapping or the default mapping of configs created without DefaultConfig
+func (config Config) columns() ColumnMapping {
+	if config.Columns == (ColumnMapping{}) {
+		return DefaultColumnMapping()
+	}
+	return config.Columns
+}
+
+// Result holds computed cohorts along with the csv headers and rows representing them, latest cohort first
+type Result struct {
+	Headers []string
+	Rows    [][]string
+	// Buckets labels every bucket reached by any cohort
+	Buckets []string
+	Cohorts []Cohort
+	// Config is the configuration the result was computed with
+	Config Config
+	// ObservedUntil is the configured extraction date or else the latest order or customer creation, buckets no customer had reached by it are censored
+	ObservedUntil time.Time
+	// Segments holds a result per value of the SegmentBy column in ascending order, segmented results hold no cohorts of their own
+	Segments []Segment
+}
+
+type Orders struct {
+	UniqueOrders    map[string]bool
+	FirstTimeOrders int
+	Count           int
+	Revenue         float64
+}
+
+type Cohort struct {
+	Dates string
+	// Start and End bound customer creation in the cohort, End is exclusive
+	Start time.Time
+	End   time.Time
+	// MaxBucket is the last bucket reached by an order, results measure every cohort up to the last bucket of any cohort
+	MaxBucket int
+	// Observed is the number of leading buckets that the earliest customer had reached by the time the data was observed until
+	Observed  int `json:"-"`
+	Customers map[string]time.Time
+	HasOrder  map[string]bool
+	Orders    map[int]Orders
+	// Currencies holds the currencies of the aggregated orders, revenue metrics fail on cohorts mixing currencies
+	Currencies map[string]bool
+	// Metrics holds the values of every configured metric once the cohort has been measured
+	Metrics []MetricValues `json:"-"`
+}
+
+// Censored reports whether no customer had reached bucket by the time the data was observed until, so its values are missing time rather than churn
+func (cohort Cohort) Censored(bucket int) bool {
+	return bucket >= cohort.Observed
+}
+
+func getStartDate(db SQL, schema Schema, filter Filter, location *time.Location) (*time.Time, error) {
+	var startDate time.Time
+
+	if rows, err := Query(db, schema.CustomersTable, schema.customerColumns(dialectOf(db)), QueryOptions{
+		Limit:   1,
+		OrderBy: schema.CustomerCreated,
+		Asc:     true,
+		Where:   allOf(isNotNull(schema.CustomerCreated), filter.condition("")),
+	}); err != nil {
+		return nil, err
+	} else {
+		var id string
+		var created string
+		if rows.Next() {
+			if err := rows.Scan(&id, &created); err != nil {
+				return nil, err
+			}
+		}
+		rows.Close()
+		startDate = parseStored(created, location)
+	}
+	return &startDate, nil
+}
+
+func getEndDate(db SQL, schema Schema, filter Filter, location *time.Location) (*time.Time, error) {
+	var endDate time.Time
+
+	if rows, err := Query(db, schema.CustomersTable, schema.customerColumns(dialectOf(db)), QueryOptions{
+		Limit:   1,
+		OrderBy: schema.CustomerCreated,
+		Asc:     false,
+		Where:   allOf(isNotNull(schema.CustomerCreated), filter.condition("")),
+	}); err != nil {
+		return nil, err
+	} else {
+		var id string
+		var created string
+		if rows.Next() {
+			if err := rows.Scan(&id, &created); err != nil {
+				return nil, err
+			}
+		}
+		rows.Close()
+		endDate = parseStored(created, location)
+	}
+	return &endDate, nil
+}
+
+// getObservedDate returns the latest order creation or the zero time if there are no orders
+func getObservedDate(db SQL, schema Schema, location *time.Location) (*time.Time, error) {
+	var observedDate time.Time
+
+	if rows, err := Query(db, schema.OrdersTable, []string{schema.OrderCustomer, dialectOf(db).Timestamp(schema.OrderCreated)}, QueryOptions{
+		Limit:   1,
+		OrderBy: schema.OrderCreated,
+		Asc:     false,
+		Where:   isNotNull(schema.OrderCreated),
+	}); err != nil {
+		return nil, err
+	} else {
+		var id string
+		var created string
+		if rows.Next() {
+			if err := rows.Scan(&id, &created); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			observedDate = parseStored(created, location)
+		}
+		rows.Close()
+	}
+	return &observedDate, nil
+}
+
+func aggregateOrders(db Executor, start, end time.Time, customers map[string]time.Time, hasOrder, currencies map[string]bool, config Config) (map[int]Orders, int, error) {
+	aggregatedOrders := map[int]Orders{}
+	schema := config.Schema
+	buckets := config.bucketing()
+	// select the orders of customers created within the window without listing their ids
+	members := dialectOf(db).Flavor().NewSelectBuilder()
+	members.Select(schema.CustomerID).From(schema.CustomersTable)
+	members.Where(config.customerWindow(dialectOf(db), start, end)(&members.Cond))
+	// query orders in ascending date order to ensure that first time orders are tied to earliest order date
+	orders, err := Query(db, schema.OrdersTable, []string{schema.OrderCustomer, dialectOf(db).Timestamp(schema.OrderCreated), schema.orderAmount(""), schema.orderCurrency("")}, QueryOptions{
+		OrderBy: schema.OrderCreated,
+		Asc:     true,
+		Where: allOf(func(cond *sqlbuilder.Cond) string {
+			return cond.In(schema.OrderCustomer, members)
+		}, config.OrderFilter.condition("")),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer orders.Close()
+	var (
+		userID   string
+		created  string
+		amount   float64
+		currency string
+	)
+	maxBucket := 0
+	for orders.Next() {
+		err := orders.Scan(&userID, &created, &amount, &currency)
+		if err != nil {
+			return nil, 0, err
+		}
+		// check if customer for given order exists in customer creation datetime map
+		if customerCreateDate, ok := customers[userID]; ok {
+			orderCreateDate := parseStored(created, config.Location)
+			// get the number of periods from customer creation the order was placed
+			bucket := buckets.Between(customerCreateDate, orderCreateDate)
+			// create an order for given bucket if it does not alrady exist
+			if _, ok := aggregatedOrders[bucket]; !ok {
+				aggregatedOrders[bucket] = Orders{
+					make(map[string]bool),
+					0,
+					0,
+					0,
+				}
+			}
+			// track max bucket from customer creation
+			if maxBucket < bucket {
+				maxBucket = bucket
+			}
+			// check if order was already placed by that customer in that bucket
+			aggregatedOrders[bucket].UniqueOrders[userID] = true
+			order := aggregatedOrders[bucket]
+			order.Count++
+			order.Revenue += amount
+			if currency != "" {
+				currencies[currency] = true
+			}
+			if _, ok := hasOrder[userID]; !ok {
+				hasOrder[userID] = true
+				// increment first time orders count if customer had not ever placed an order
+				order.FirstTimeOrders++
+			}
+			aggregatedOrders[bucket] = order
+		}
+	}
+	return aggregatedOrders, maxBucket, nil
+}
+
+// newCohort creates an empty cohort of customers created from start until end
+func newCohort(start, end time.Time) Cohort {
+	return Cohort{
+		fmt.Sprintf("%s-%s", start.Format("01/02/2006"), end.AddDate(0, 0, -1).Format("01/02/2006")),
+		start,
+		end,
+		0,
+		0,
+		make(map[string]time.Time),
+		make(map[string]bool),
+		make(map[int]Orders),
+		make(map[string]bool),
+		nil,
+	}
+}
+
+func generateCohort(db Executor, customers *sql.Rows, start, end time.Time, config Config) (Cohort, error) {
+	var id string
+	var created string
+	cohort := newCohort(start, end)
+	defer customers.Close()
+	for customers.Next() {
+		err := customers.Scan(&id, &created)
+		if err != nil {
+			return cohort, err
+		}
+		cohort.Customers[id] = parseStored(created, config.Location)
+	}
+	if err := customers.Err(); err != nil {
+		return cohort, err
+	}
+	// query for orders that come from specified customers and aggregate on periods from sign up date
+	aggregatedOrders, maxBucket, err := aggregateOrders(db, start, end, cohort.Customers, cohort.HasOrder, cohort.Currencies, config)
+	if err != nil {
+		return cohort, err
+	}
+	cohort.Orders = aggregatedOrders
+	cohort.MaxBucket = maxBucket
+	return cohort, nil
+}
+
+// aggregateCohort aggregates the orders of customers created between start and end with the configured engine
+func aggregateCohort(source SQL, start, end time.Time, config Config) (Cohort, error) {
+	if config.Engine == SQLEngine {
+		return queryCohort(source, start, end, config)
+	}
+	rows, err := Query(source, config.Schema.CustomersTable, config.Schema.customerColumns(dialectOf(source)), QueryOptions{
+		OrderBy: config.Schema.CustomerCreated,
+		Asc:     true,
+		Where:   config.customerWindow(dialectOf(source), start, end),
+	})
+	if err != nil {
+		return Cohort{}, err
+	}
+	// generate cohort data from customers returned from query
+	return generateCohort(source, rows, start, end, config)
+}
+
+// loadOrGenerateCohort aggregates the orders of customers created between start and end, incremental configs reuse unchanged cached cohorts and report whether the cohort was cached
+func loadOrGenerateCohort(source SQL, start, end time.Time, config Config) (Cohort, bool, error) {
+	if config.Incremental {
+		if cohort, ok, err := loadCohort(source, start, end, config); err != nil || ok {
+			return cohort, ok, err
+		}
+	}
+	cohort, err := aggregateCohort(source, start, end, config)
+	return cohort, false, err
+}
+
+func measureCohort(cohort Cohort, config Config) ([]MetricValues, error) {
+	measured := make([]MetricValues, len(config.Metrics))
+	for i, name := range config.Metrics {
+		metric, err := NewMetric(name)
+		if err != nil {
+			return nil, err
+		}
+		// revenue summed across currencies means nothing
+		if revenueMetrics[name] && len(cohort.Currencies) > 1 {
+			currencies := []string{}
+			for currency := range cohort.Currencies {
+				currencies = append(currencies, currency)
+			}
+			sort.Strings(currencies)
+			return nil, fmt.Errorf("Cohort %s mixes the currencies %s, the %s metric requires orders of a single currency", cohort.Dates, strings.Join(currencies, ", "), name)
+		}
+		measured[i] = MetricValues{metric, make([]Value, 0, cohort.MaxBucket+1)}
+	}
+	// iteratively go through buckets until bucket exceeds max bucket for order from customer creation
+	for bucket := 0; bucket <= cohort.MaxBucket; bucket++ {
+		// accumulate orders placed in bucket into every metric
+		orders := cohort.Orders[bucket]
+		for i, values := range measured {
+			values.Metric.Accumulate(cohort, orders)
+			measured[i].Values = append(measured[i].Values, values.Metric.Finalize(cohort))
+		}
+	}
+	return measured, nil
+}
+
+func makeCohortRows(cohort Cohort, headers *OrderedStringSet, buckets bucketing) [][]string {
+	// set default header values
+	headers.Add("Cohort").Add("Customers")
+	for bucket := 0; bucket <= cohort.MaxBucket; bucket++ {
+		// set unique bucket ranges to headers
+		headers.Add(buckets.Header(bucket))
+	}
+	rows := make([][]string, len(cohort.Metrics))
+	for i, values := range cohort.Metrics {
+		if i == 0 {
+			rows[i] = []string{cohort.Dates, fmt.Sprintf("%d customers", len(cohort.Customers))}
+		} else {
+			rows[i] = []string{"", ""}
+		}
+		// format the value of every bucket for the metric csv row
+		for bucket, value := range values.Values {
+			if cohort.Censored(bucket) {
+				rows[i] = append(rows[i], CensoredCell)
+				continue
+			}
+			rows[i] = append(rows[i], values.Metric.Format(value))
+		}
+	}
+	return rows
+}
+
+// CensoredCell fills the csv cells of buckets that no customer had reached by the time the data was observed until
+const CensoredCell = "NA"
+
+// cohortWindow bounds the creation dates of the customers in a cohort, end is exclusive
+type cohortWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// cohortWindows returns the windows of consecutive cohorts from the one containing startDate until the one containing endDate
+func cohortWindows(startDate, endDate time.Time, config Config) []cohortWindow {
+	windows := []cohortWindow{}
+	for {
+		year, month, day := startDate.Date()
+		gte := config.CohortPeriod.Truncate(time.Date(year, month, day, 0, 0, 0, 0, config.Location))
+		lt := config.CohortPeriod.Add(gte, 1)
+		windows = append(windows, cohortWindow{gte, lt})
+		startDate = lt
+		if startDate.After(endDate) {
+			break
+		}
+	}
+	return windows
+}
+
+// observedBuckets returns the number of leading buckets up to the max bucket of cohort that its earliest customer had reached by observed
+func observedBuckets(cohort Cohort, buckets bucketing, observed time.Time) int {
+	var earliest time.Time
+	for _, created := range cohort.Customers {
+		if earliest.IsZero() || created.Before(earliest) {
+			earliest = created
+		}
+	}
+	if earliest.IsZero() {
+		earliest = cohort.Start
+	}
+	count := 0
+	for count <= cohort.MaxBucket {
+		start := earliest
+		if count > 0 {
+			start = buckets.end(earliest, count-1)
+		}
+		if start.After(observed) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// measureUntil measures cohort up to maxBucket, carrying retained customers into the buckets past its last order and marking buckets not yet observed as censored
+func measureUntil(cohort Cohort, maxBucket int, observed time.Time, config Config) (Cohort, error) {
+	cohort.MaxBucket = maxBucket
+	cohort.Orders = retainOrders(cohort, config.RetentionMode)
+	cohort.Observed = observedBuckets(cohort, config.bucketing(), observed)
+	var err error
+	cohort.Metrics, err = measureCohort(cohort, config)
+	return cohort, err
+}
+
+// computedCohort is an aggregated cohort along with whether it was loaded from the cohort cache
+type computedCohort struct {
+	cohort Cohort
+	cached bool
+	err    error
+}
+
+// computeCohort aggregates the cohort of customers created within window
+func computeCohort(source SQL, window cohortWindow, config Config) computedCohort {
+	cohort, cached, err := loadOrGenerateCohort(source, window.start, window.end, config)
+	return computedCohort{cohort, cached, err}
+}
+
+// computeCohorts computes the cohort of every window on a bounded pool of config.Workers goroutines, each query runs on its own pooled connection
+func computeCohorts(ctx context.Context, source SQL, windows []cohortWindow, config Config) ([]computedCohort, error) {
+	computed := make([]computedCohort, len(windows))
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// a failing cohort stops the remaining windows from being handed out
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	wait := sync.WaitGroup{}
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range indexes {
+				computed[index] = computeCohort(source, windows[index], config)
+				if computed[index].err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+dispatch:
+	for index := range windows {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wait.Wait()
+	for _, cohort := range computed {
+		if cohort.err != nil {
+			return nil, cohort.err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return computed, nil
+}
+
+// Compute groups the customers in source into cohorts and aggregates their orders as defined by config
+func Compute(ctx context.Context, source SQL, config Config) (Result, error) {
+	if config.Schema == (Schema{}) {
+		config.Schema = DefaultSchema()
+	}
+	result := Result{Config: config}
+	if err := config.Schema.Validate(); err != nil {
+		return result, err
+	}
+	if len(config.Buckets) != 0 {
+		if err := config.Buckets.Validate(); err != nil {
+			return result, err
+		}
+	}
+	// the import bookkeeping of incremental configs only tracks the tables written by imports
+	if config.Incremental && config.Schema != DefaultSchema() {
+		return result, errors.New("Incremental cohorts require the default schema")
+	}
+	// datetimes compared to the created column of customers are read in the reporting timezone
+	var err error
+	dialect := dialectOf(source)
+	if config.CustomerFilter, err = config.CustomerFilter.stored(dialect, config.Schema.members(config.cohortBy()).CustomerCreated, config.Location); err != nil {
+		return result, err
+	}
+	if config.OrderFilter, err = config.OrderFilter.stored(dialect, config.Schema.OrderCreated, config.Location); err != nil {
+		return result, err
+	}
+	if config.SegmentBy != "" {
+		return computeSegments(ctx, source, config)
+	}
+	return computeResult(ctx, source, config)
+}
+
+// computeResult computes the cohorts of a validated config
+func computeResult(ctx context.Context, source SQL, config Config) (Result, error) {
+	result := Result{Config: config}
+	// customers are read from the first orders of buyers past this point for first order cohorts
+	config.Schema = config.Schema.members(config.cohortBy(), config.attributes()...)
+	// query customer table for earliest filtered customer creation date
+	startDate, err := getStartDate(source, config.Schema, config.CustomerFilter, config.Location)
+	if err != nil {
+		return result, err
+	}
+	// query customer table for latest filtered customer creation date
+	endDate, err := getEndDate(source, config.Schema, config.CustomerFilter, config.Location)
+	if err != nil {
+		return result, err
+	}
+	// buckets ending after the extraction date or else the latest order or customer are censored
+	result.ObservedUntil = config.ObservedUntil
+	if result.ObservedUntil.IsZero() {
+		observedDate, err := getObservedDate(source, config.Schema, config.Location)
+		if err != nil {
+			return result, err
+		}
+		result.ObservedUntil = *observedDate
+		if endDate.After(result.ObservedUntil) {
+			result.ObservedUntil = *endDate
+		}
+	}
+	// narrow the dates cohorts start between to the configured range
+	if !config.From.IsZero() && config.From.After(*startDate) {
+		startDate = &config.From
+	}
+	if !config.To.IsZero() && config.To.Before(*endDate) {
+		endDate = &config.To
+	}
+	if startDate.After(*endDate) {
+		return result, nil
+	}
+	windows := cohortWindows(*startDate, *endDate, config)
+	computed, err := computeCohorts(ctx, source, windows, config)
+	if err != nil {
+		return result, err
+	}
+	// every cohort is measured up to the last bucket reached by any cohort so rows share the header width
+	maxBucket := 0
+	for _, cohort := range computed {
+		if cohort.cohort.MaxBucket > maxBucket {
+			maxBucket = cohort.cohort.MaxBucket
+		}
+	}
+	for bucket := 0; bucket <= maxBucket; bucket++ {
+		result.Buckets = append(result.Buckets, config.bucketing().Header(bucket))
+	}
+	// merge cohorts in chronological order so headers and rows do not depend on the order workers finished in
+	headers := NewOrderedStringSet()
+	for _, computedCohort := range computed {
+		if config.Incremental && !computedCohort.cached {
+			if err := storeCohort(source, computedCohort.cohort, config); err != nil {
+				return result, err
+			}
+		}
+		cohort, err := measureUntil(computedCohort.cohort, maxBucket, result.ObservedUntil, config)
+		if err != nil {
+			return result, err
+		}
+		result.Cohorts = append(result.Cohorts, cohort)
+		// convert cohort struct data to rows comforming to expected format ahead of earlier cohorts
+		result.Rows = append(makeCohortRows(cohort, &headers, config.bucketing()), result.Rows...)
+	}
+	result.Headers = headers.Values()
+	return result, nil
+}
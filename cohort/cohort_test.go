@@ -0,0 +1,245 @@
+package cohort
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeTestSource creates a temporary database holding the given customer (id, created) and order (id, order_number, user_id, created, amount) rows
+func makeTestSource(t testing.TB, customers, orders [][]interface{}) (SQL, func()) {
+	tmp, err := ioutil.TempFile("", "test-cohort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	db, err := ConnectDB(true, tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeTables(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, customer := range customers {
+		if err := Insert(db, "customers", []string{"id", "created"}, customer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, order := range orders {
+		if err := Insert(db, "orders", []string{"id", "order_number", "user_id", "created", "amount"}, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(tmp.Name())
+	}
+}
+
+func TestCompute(t *testing.T) {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{33559, "2015-06-19T23:49:32"},
+		{33563, "2015-06-20T00:09:03"},
+		{33600, "2015-06-27T10:00:00"},
+	}, [][]interface{}{
+		{26444, 1, 33563, "2015-06-25T01:27:40", 10.0},
+		{26445, 2, 33563, "2015-06-26T01:27:40", 30.0},
+		{26446, 1, 33559, "2015-07-04T10:00:00", 20.0},
+	})
+	defer cleanup()
+
+	result, err := Compute(context.Background(), db, DefaultConfig())
+	assert.NoError(t, err, "should compute cohorts without error")
+	assert.Equal(t, []string{"Cohort", "Customers", "0-6", "7-13", "14-20"}, result.Headers, "should include headers for every bucket")
+	assert.Equal(t, 2, len(result.Cohorts), "should group customers into weekly cohorts")
+	assert.Equal(t, "2015-07-04T10:00:00Z", result.ObservedUntil.Format(time.RFC3339), "should observe the data until the latest order")
+	assert.Equal(t, []string{"06/26/2015-07/02/2015", "1 customers", "0% orderers (0)", "0% orderers (0)", "NA"}, result.Rows[0], "should include cohorts without orders ahead of earlier cohorts padded with censored buckets")
+	assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)", "0% orderers (0)", "50.00% orderers (1)"}, result.Rows[2], "should not censor buckets the earliest customer of a cohort had reached")
+	assert.Equal(t, 3, result.Cohorts[0].Observed, "should count the observed buckets of every cohort")
+
+	config := DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts observed until a later extraction date without error")
+	assert.Equal(t, []string{"06/26/2015-07/02/2015", "1 customers", "0% orderers (0)", "0% orderers (0)", "0% orderers (0)"}, result.Rows[0], "should pad cohorts to the header width")
+	assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)", "0% orderers (0)", "50.00% orderers (1)"}, result.Rows[2], "should count unique orderers per bucket")
+	assert.Equal(t, []string{"", "", "50.00% 1st time (1)", "0% 1st time (0)", "50.00% 1st time (1)"}, result.Rows[3], "should count first time orderers per bucket")
+
+	config.Metrics = append(config.Metrics, "ltv", "aov", "nrr")
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute revenue cohorts without error")
+	assert.Equal(t, 10, len(result.Rows), "should add three revenue rows to every cohort")
+	assert.Equal(t, []string{"", "", "20.00 ltv (40.00)", "20.00 ltv (40.00)", "30.00 ltv (60.00)"}, result.Rows[7], "should report cumulative revenue per customer")
+	assert.Equal(t, []string{"", "", "20.00 aov (2)", "0.00 aov (0)", "20.00 aov (1)"}, result.Rows[8], "should report average order value per bucket")
+	assert.Equal(t, []string{"", "", "100.00% nrr (40.00)", "0.00% nrr (0.00)", "50.00% nrr (20.00)"}, result.Rows[9], "should report revenue relative to first bucket")
+
+	config.Workers = 4
+	parallel, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts concurrently without error")
+	assert.Equal(t, result.Headers, parallel.Headers, "should merge headers of concurrent cohorts in order")
+	assert.Equal(t, result.Rows, parallel.Rows, "should merge rows of concurrent cohorts in order")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Compute(ctx, db, DefaultConfig())
+	assert.Error(t, err, "should stop computing when context is cancelled")
+
+	config.Metrics = []string{"churn"}
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on unknown metrics")
+}
+
+func TestTimezone(t *testing.T) {
+	// daylight saving time starts in New York on 2015-03-08, datetimes are stored as UTC instants
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		// 23:30 on 2015-03-07 in New York
+		{1, "2015-03-08T04:30:00"},
+		// 00:30 on 2015-03-08 in New York
+		{2, "2015-03-08T05:30:00"},
+		{3, "2015-03-08T15:00:00"},
+	}, [][]interface{}{
+		// 23:30 on 2015-03-08 in New York, 23 hours after signup
+		{1, 1, 1, "2015-03-09T03:30:00", 5.0},
+		// 00:30 on 2015-03-09 in New York, 23 hours after signup
+		{2, 1, 2, "2015-03-09T04:30:00", 10.0},
+		{3, 1, 3, "2015-11-02T16:00:00", 2.5},
+	})
+	defer cleanup()
+
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err, "should load timezone data")
+	config := DefaultConfig()
+	config.Location = newYork
+	config.CohortPeriod = Day
+	config.BucketPeriod = Day
+	result, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts in a timezone without error")
+	assert.Equal(t, 2, len(result.Cohorts), "should window cohorts by the calendar days of the timezone")
+	earliest, latest := result.Cohorts[0], result.Cohorts[1]
+	assert.Equal(t, "03/07/2015-03/07/2015", earliest.Dates, "should place customers signing up before local midnight in the previous day")
+	assert.Equal(t, 1, len(earliest.Customers), "should hold the customer of the local day")
+	assert.True(t, time.Date(2015, time.March, 8, 0, 0, 0, 0, newYork).Equal(latest.Start), "should start cohorts at local midnight")
+	assert.True(t, time.Date(2015, time.March, 9, 0, 0, 0, 0, newYork).Equal(latest.End), "should end cohorts at the next local midnight")
+	assert.Equal(t, 23*time.Hour, latest.End.Sub(latest.Start), "should shorten the cohort of the transition day")
+	assert.Equal(t, 2, len(latest.Customers), "should hold the customers of the transition day")
+	assert.Equal(t, newYork, latest.Customers["2"].Location(), "should read customer datetimes in the timezone")
+	assert.True(t, earliest.Orders[1].UniqueOrders["1"], "should bucket orders a wall clock day after signup into the second day")
+	assert.True(t, latest.Orders[1].UniqueOrders["2"], "should bucket orders by wall clock days across the transition")
+	assert.True(t, latest.Orders[239].UniqueOrders["3"], "should bucket orders by wall clock days after daylight saving time ends")
+
+	for _, period := range []Period{Day, Week, Month} {
+		config.BucketPeriod = period
+		config.Engine = MemoryEngine
+		memory, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts in memory without error")
+		config.Engine = SQLEngine
+		database, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts in sql without error")
+		assert.Equal(t, memory.Rows, database.Rows, "should bucket identically in the timezone for "+string(period))
+	}
+
+	config = DefaultConfig()
+	config.CohortPeriod = Day
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts in UTC without error")
+	assert.Equal(t, 1, len(result.Cohorts), "should window the same instants by UTC days")
+}
+
+func TestFirstOrderCohorts(t *testing.T) {
+	_, err := ParseCohortBy("last_order")
+	assert.Error(t, err, "should fail on parsing unsupported cohort membership")
+
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{1, "2015-01-01T00:00:00"},
+		{2, "2015-01-02T00:00:00"},
+	}, [][]interface{}{
+		{1, 1, 1, "2015-01-10T10:00:00", 10.0},
+		{2, 2, 1, "2015-01-18T11:00:00", 20.0},
+		// customers 3 and 4 are only known from their orders
+		{3, 1, 3, "2015-01-12T00:00:00", 5.0},
+		{4, 2, 3, "2015-01-12T05:00:00", 5.0},
+		{5, 1, 4, "2015-01-20T00:00:00", 15.0},
+	})
+	defer cleanup()
+
+	config := DefaultConfig()
+	config.CohortBy = FirstOrderCohorts
+	config.ObservedUntil = time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		result, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute first order cohorts without error")
+		assert.Equal(t, []string{"Cohort", "Customers", "0-6", "7-13"}, result.Headers, "should bucket orders from the first order")
+		assert.Equal(t, 2, len(result.Cohorts), "should start cohorts at the earliest first order")
+		assert.Equal(t, map[string]time.Time{
+			"1": time.Date(2015, 1, 10, 10, 0, 0, 0, time.UTC),
+			"3": time.Date(2015, 1, 12, 0, 0, 0, 0, time.UTC),
+		}, result.Cohorts[0].Customers, "should group buyers by their first order and leave out customers without orders")
+		assert.Equal(t, []string{"01/17/2015-01/23/2015", "1 customers", "100.00% orderers (1)", "0% orderers (0)"}, result.Rows[0], "should include buyers missing from the customers table")
+		assert.Equal(t, []string{"01/10/2015-01/16/2015", "2 customers", "100.00% orderers (2)", "50.00% orderers (1)"}, result.Rows[2], "should count the first order of every buyer in the first bucket")
+		assert.Equal(t, []string{"", "", "100.00% 1st time (2)", "0% 1st time (0)"}, result.Rows[3], "should count every buyer as first time in the first bucket")
+	}
+}
+
+func TestCohortBoundaries(t *testing.T) {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		// the earliest customer signs up exactly at midnight
+		{1, "2015-06-19T00:00:00"},
+		// exactly at the end of the first weekly window
+		{2, "2015-06-26T00:00:00"},
+		{3, "2015-06-27T10:00:00"},
+	}, [][]interface{}{
+		{1, 1, 1, "2015-06-19T00:00:00", 10.0},
+		{2, 1, 2, "2015-06-26T00:00:00", 10.0},
+	})
+	defer cleanup()
+	keys := func(customers map[string]time.Time) []string {
+		ids := []string{}
+		for id := range customers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	config := DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		config.CohortBy = SignupCohorts
+		result, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts without error")
+		assert.Equal(t, 2, len(result.Cohorts), "should window cohorts from the earliest customer")
+		assert.Equal(t, []string{"1"}, keys(result.Cohorts[0].Customers), "should hold customers created at the start of a window with the "+string(engine)+" engine")
+		assert.Equal(t, []string{"2", "3"}, keys(result.Cohorts[1].Customers), "should hold customers created at the end of a window in the next one with the "+string(engine)+" engine")
+
+		config.CohortBy = FirstOrderCohorts
+		result, err = Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute first order cohorts without error")
+		assert.Equal(t, 2, len(result.Cohorts), "should window first order cohorts from the earliest buyer")
+		assert.Equal(t, []string{"1"}, keys(result.Cohorts[0].Customers), "should hold buyers ordering at the start of a window with the "+string(engine)+" engine")
+		assert.Equal(t, []string{"2"}, keys(result.Cohorts[1].Customers), "should hold buyers ordering at the end of a window in the next one with the "+string(engine)+" engine")
+	}
+
+	dates, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	customers := writeTestCSV(t, "id,created\n1,2015-06-19\n2,2015-06-20\n3,2015-06-20\n")
+	defer os.Remove(customers)
+	_, err := ImportCustomers(dates, customers, config)
+	assert.NoError(t, err, "should import date only customers")
+	config.CohortBy = SignupCohorts
+	config.CohortPeriod = Day
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		result, err := Compute(context.Background(), dates, config)
+		assert.NoError(t, err, "should compute daily cohorts of date only customers without error")
+		assert.Equal(t, 2, len(result.Cohorts), "should window date only customers by day")
+		assert.Equal(t, []string{"1"}, keys(result.Cohorts[0].Customers), "should hold the customers of the first day with the "+string(engine)+" engine")
+		assert.Equal(t, []string{"2", "3"}, keys(result.Cohorts[1].Customers), "should hold the customers of the second day with the "+string(engine)+" engine")
+	}
+}
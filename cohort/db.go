@@ -0,0 +1,265 @@
+package cohort
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Executor runs statements against a database or within a transaction
+type Executor interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+type SQL interface {
+	Executor
+	Close() error
+	Begin() (*sql.Tx, error)
+}
+
+// database is a connection pool along with the dialect of its driver
+type database struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (db database) Dialect() Dialect {
+	return db.dialect
+}
+
+// transaction is a transaction along with the dialect of its driver
+type transaction struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx transaction) Dialect() Dialect {
+	return tx.dialect
+}
+
+// begin starts a transaction on db keeping its dialect
+func begin(db SQL) (transaction, error) {
+	tx, err := db.Begin()
+	return transaction{tx, dialectOf(db)}, err
+}
+
+// savepoint runs write within a savepoint of tx so a failed write is undone without aborting tx
+func savepoint(tx transaction, write func() error) error {
+	if _, err := tx.Exec("SAVEPOINT import_batch"); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		if _, rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT import_batch"); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT import_batch")
+	return err
+}
+
+// Connect opens a database with a registered driver and optionally drops any existing tables
+func Connect(driver, dsn string, drop bool) (SQL, error) {
+	dialect, err := NewDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+	if drop && driver == "sqlite3" {
+		os.Remove(dsn)
+	}
+	pool, err := sql.Open(driver, dialect.DSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+	db := database{pool, dialect}
+	if drop && driver != "sqlite3" {
+		for _, table := range tables {
+			if _, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table)); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
+
+// ConnectDB creates a sqlite db instance and optionally drops any existing tables
+func ConnectDB(drop bool, dbname string) (SQL, error) {
+	return Connect("sqlite3", dbname, drop)
+}
+
+func CreateTable(db SQL, table string, columns map[string]string) error {
+	dialect := dialectOf(db)
+	builder := sqlbuilder.NewCreateTableBuilder().
+		CreateTable(table).
+		IfNotExists()
+
+	for col, typedef := range columns {
+		builder.Define(col, dialect.ColumnType(typedef))
+	}
+
+	statement, args := builder.BuildWithFlavor(dialect.Flavor())
+	if _, err := db.Exec(statement, args...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateIndex indexes the named columns of table
+func CreateIndex(db SQL, table string, columns ...string) error {
+	return dialectOf(db).CreateIndex(db, table, table+"_"+strings.Join(columns, "_"), columns)
+}
+
+// Insert adds a row of values to the named columns of table
+func Insert(db Executor, table string, columns []string, values []interface{}) error {
+	builder := sqlbuilder.NewInsertBuilder().
+		InsertInto(table).
+		Cols(columns...).
+		Values(values...)
+
+	statement, args := builder.BuildWithFlavor(dialectOf(db).Flavor())
+	if _, err := db.Exec(statement, args...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Upsert adds a row of values to the named columns of table replacing any row with the same value in the first column, its primary key
+func Upsert(db Executor, table string, columns []string, values []interface{}) error {
+	statement, args := dialectOf(db).Upsert(table, columns, [][]interface{}{values})
+	if _, err := db.Exec(statement, args...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// maxVariables is the number of parameters sqlite accepts in a single statement
+const maxVariables = 999
+
+// Batch buffers rows written to a table within a transaction as multi-row statements
+type Batch struct {
+	tx      *sql.Tx
+	dialect Dialect
+	table   string
+	columns []string
+	// replace upserts rows by primary key instead of inserting them
+	replace bool
+	size    int
+	values  []interface{}
+	// statement is prepared once for full batches
+	statement *sql.Stmt
+}
+
+// NewBatch creates a Batch writing up to size rows per statement to the named columns of table, size is capped to the parameters a statement accepts
+func NewBatch(tx *sql.Tx, dialect Dialect, table string, columns []string, size int, replace bool) *Batch {
+	if size < 1 {
+		size = 1
+	}
+	if size*len(columns) > maxVariables {
+		size = maxVariables / len(columns)
+	}
+	return &Batch{tx: tx, dialect: dialect, table: table, columns: columns, replace: replace, size: size}
+}
+
+func (batch *Batch) build() (string, []interface{}) {
+	rows := make([][]interface{}, 0, len(batch.values)/len(batch.columns))
+	for i := 0; i < len(batch.values); i += len(batch.columns) {
+		rows = append(rows, batch.values[i:i+len(batch.columns)])
+	}
+	if batch.replace {
+		return batch.dialect.Upsert(batch.table, batch.columns, rows)
+	}
+	return insertRows(batch.dialect.Flavor().NewInsertBuilder().InsertInto(batch.table), batch.columns, rows).Build()
+}
+
+// Add buffers a row of values in column order
+func (batch *Batch) Add(values []interface{}) {
+	batch.values = append(batch.values, values...)
+}
+
+// Full reports whether the batch holds as many rows as a statement writes
+func (batch *Batch) Full() bool {
+	return len(batch.values) >= batch.size*len(batch.columns)
+}
+
+// Flush writes the buffered rows, full batches reuse a prepared statement
+func (batch *Batch) Flush() error {
+	if len(batch.values) == 0 {
+		return nil
+	}
+	statement, args := batch.build()
+	var err error
+	if batch.Full() {
+		if batch.statement == nil {
+			if batch.statement, err = batch.tx.Prepare(statement); err != nil {
+				return err
+			}
+		}
+		_, err = batch.statement.Exec(args...)
+	} else {
+		_, err = batch.tx.Exec(statement, args...)
+	}
+	batch.values = batch.values[:0]
+	return err
+}
+
+// Close releases the prepared statement without writing buffered rows
+func (batch *Batch) Close() error {
+	if batch.statement == nil {
+		return nil
+	}
+	return batch.statement.Close()
+}
+
+type QueryOptions struct {
+	OrderBy string
+	Asc     bool
+	// Where is a condition on the selected rows
+	Where  Condition
+	Limit  int
+	Offset int
+}
+
+// buildQuery builds a select statement for the placeholders of dialect
+func buildQuery(dialect Dialect, table string, sel []string, options QueryOptions) (string, []interface{}) {
+	builder := dialect.Flavor().NewSelectBuilder().
+		From(table).
+		Select(sel...)
+
+	if options.Where != nil {
+		builder.Where(options.Where(&builder.Cond))
+	}
+
+	if options.OrderBy != "" {
+		builder.OrderBy(options.OrderBy)
+		if options.Asc {
+			builder.Asc()
+		} else {
+			builder.Desc()
+		}
+	}
+
+	if options.Limit != 0 {
+		builder.Limit(options.Limit)
+	}
+
+	if options.Offset != 0 {
+		builder.Offset(options.Offset)
+	}
+
+	return builder.Build()
+}
+
+func Query(db Executor, table string, sel []string, options QueryOptions) (*sql.Rows, error) {
+	statement, args := buildQuery(dialectOf(db), table, sel, options)
+	result, err := db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,342 @@
+package cohort
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect adapts the statements of the import and cohort pipeline to a database driver
+type Dialect interface {
+	// Flavor is the go-sqlbuilder flavor defining the placeholders of built statements
+	Flavor() sqlbuilder.Flavor
+	// DSN adds any connection options the pipeline relies on to dsn
+	DSN(dsn string) string
+	// ColumnType translates a column definition of the sqlite schemas
+	ColumnType(definition string) string
+	// Upsert builds a statement writing rows of values to the named columns of table, rows replace stored rows with the same value in the first column
+	Upsert(table string, columns []string, rows [][]interface{}) (string, []interface{})
+	// CreateIndex indexes the named columns of table unless the index exists
+	CreateIndex(db Executor, table, name string, columns []string) error
+	// Timestamp returns an expression formatting a datetime column like the sqlite driver scans datetimes into strings
+	Timestamp(column string) string
+	// Datetime returns an expression comparing a datetime column against the stored datetimes bound to statements whatever layout it is stored in
+	Datetime(column string) string
+	// Shift returns an expression moving a datetime expression by a number of seconds
+	Shift(column string, seconds int) string
+	// Bucket returns an expression for the whole periods elapsed from the start to the end datetime expressions as Period.Between computes them
+	Bucket(period Period, start, end string) string
+	// Constraint reports whether err is a statement refused for violating a constraint of its table
+	Constraint(err error) bool
+}
+
+var dialectRegistry = map[string]Dialect{}
+
+// RegisterDialect makes a dialect used for connections opened with the named database/sql driver
+func RegisterDialect(driver string, dialect Dialect) {
+	dialectRegistry[driver] = dialect
+}
+
+// NewDialect returns the dialect registered for driver
+func NewDialect(driver string) (Dialect, error) {
+	dialect, ok := dialectRegistry[driver]
+	if !ok {
+		return nil, fmt.Errorf("Unknown driver %q expected one of %v", driver, DialectDrivers())
+	}
+	return dialect, nil
+}
+
+// DialectDrivers returns the sorted drivers of all registered dialects
+func DialectDrivers() []string {
+	drivers := make([]string, 0, len(dialectRegistry))
+	for driver := range dialectRegistry {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return drivers
+}
+
+func init() {
+	RegisterDialect("sqlite3", SQLiteDialect{})
+	RegisterDialect("postgres", PostgresDialect{})
+	RegisterDialect("mysql", MySQLDialect{})
+}
+
+// dialectOf returns the dialect of the connection db runs statements on, defaulting to sqlite
+func dialectOf(db Executor) Dialect {
+	if db, ok := db.(interface{ Dialect() Dialect }); ok {
+		return db.Dialect()
+	}
+	return SQLiteDialect{}
+}
+
+// insertRows adds rows of values to an insert builder
+func insertRows(builder *sqlbuilder.InsertBuilder, columns []string, rows [][]interface{}) *sqlbuilder.InsertBuilder {
+	builder.Cols(columns...)
+	for _, row := range rows {
+		builder.Values(row...)
+	}
+	return builder
+}
+
+// periodExpressions builds Period.Between expressions out of the date arithmetic of a dialect
+type periodExpressions struct {
+	// seconds returns the whole seconds elapsed from start to end
+	seconds func(start, end string) string
+	// months returns the calendar months elapsed from start to end ignoring the day of month
+	months func(start, end string) string
+	// addMonths moves start by months overflowing into the next month as time.AddDate does, dialects clamping the day of month add months to the first of the month and the offset of start into its month afterwards
+	addMonths func(start, months string) string
+	// floorDiv rounds the integer division of value by divisor towards negative infinity
+	floorDiv func(value string, divisor int) string
+	// truth converts a condition into 1 or 0
+	truth func(condition string) string
+}
+
+func (expressions periodExpressions) bucket(period Period, start, end string) string {
+	if months := period.months(); months != 0 {
+		elapsed := expressions.months(start, end)
+		// step back a month when the day of month or time of day has not yet been reached
+		elapsed = fmt.Sprintf("(%s - %s)", elapsed, expressions.truth(fmt.Sprintf("%s > %s", expressions.addMonths(start, elapsed), end)))
+		return expressions.floorDiv(elapsed, months)
+	}
+	// whole days elapsed are floored like Period.Between ahead of flooring into periods
+	return expressions.floorDiv(expressions.floorDiv(expressions.seconds(start, end), 86400), period.days())
+}
+
+// SQLiteDialect stores data in sqlite, the default
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Flavor() sqlbuilder.Flavor {
+	return sqlbuilder.MySQL
+}
+
+func (SQLiteDialect) DSN(dsn string) string {
+	return dsn
+}
+
+func (SQLiteDialect) ColumnType(definition string) string {
+	return definition
+}
+
+func (SQLiteDialect) Upsert(table string, columns []string, rows [][]interface{}) (string, []interface{}) {
+	return insertRows(sqlbuilder.MySQL.NewInsertBuilder().ReplaceInto(table), columns, rows).Build()
+}
+
+func (SQLiteDialect) CreateIndex(db Executor, table, name string, columns []string) error {
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", ")))
+	return err
+}
+
+func (SQLiteDialect) Timestamp(column string) string {
+	return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %s)", column)
+}
+
+// Datetime reformats the text sqlite stores datetimes as so tables written with a space separator or without a time compare alike
+func (SQLiteDialect) Datetime(column string) string {
+	return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%S', %s)", column)
+}
+
+func (SQLiteDialect) Constraint(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+}
+
+func (SQLiteDialect) Shift(column string, seconds int) string {
+	return fmt.Sprintf("datetime(%s, '%+d seconds')", column, seconds)
+}
+
+var sqlitePeriods = periodExpressions{
+	seconds: func(start, end string) string {
+		return fmt.Sprintf("(strftime('%%s', %s) - strftime('%%s', %s))", end, start)
+	},
+	months: func(start, end string) string {
+		return fmt.Sprintf("((CAST(strftime('%%Y', %[2]s) AS INTEGER) - CAST(strftime('%%Y', %[1]s) AS INTEGER)) * 12 + CAST(strftime('%%m', %[2]s) AS INTEGER) - CAST(strftime('%%m', %[1]s) AS INTEGER))", start, end)
+	},
+	addMonths: func(start, months string) string {
+		// the months modifier of datetime already overflows the day of month
+		return fmt.Sprintf("datetime(%s, %s || ' months')", start, months)
+	},
+	floorDiv: func(value string, divisor int) string {
+		return fmt.Sprintf("((%s) / %d - ((%s) %% %d < 0))", value, divisor, value, divisor)
+	},
+	truth: func(condition string) string {
+		return fmt.Sprintf("(%s)", condition)
+	},
+}
+
+func (SQLiteDialect) Bucket(period Period, start, end string) string {
+	return sqlitePeriods.bucket(period, fmt.Sprintf("datetime(%s)", start), fmt.Sprintf("datetime(%s)", end))
+}
+
+// PostgresDialect stores data in PostgreSQL through the postgres driver
+type PostgresDialect struct{}
+
+func (PostgresDialect) Flavor() sqlbuilder.Flavor {
+	return sqlbuilder.PostgreSQL
+}
+
+func (PostgresDialect) DSN(dsn string) string {
+	return dsn
+}
+
+func (PostgresDialect) ColumnType(definition string) string {
+	definition = strings.Replace(definition, "datetime", "timestamp", 1)
+	return strings.Replace(definition, "real", "double precision", 1)
+}
+
+// Upsert keeps the last of rows sharing a key as postgres refuses to update a row twice in one statement
+func (PostgresDialect) Upsert(table string, columns []string, rows [][]interface{}) (string, []interface{}) {
+	last := make(map[interface{}]int, len(rows))
+	for i, row := range rows {
+		last[row[0]] = i
+	}
+	if len(last) != len(rows) {
+		unique := make([][]interface{}, 0, len(last))
+		for i, row := range rows {
+			if last[row[0]] == i {
+				unique = append(unique, row)
+			}
+		}
+		rows = unique
+	}
+	statement, args := insertRows(sqlbuilder.PostgreSQL.NewInsertBuilder().InsertInto(table), columns, rows).Build()
+	updates := make([]string, 0, len(columns)-1)
+	for _, column := range columns[1:] {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", statement, columns[0]), args
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", statement, columns[0], strings.Join(updates, ", ")), args
+}
+
+func (PostgresDialect) CreateIndex(db Executor, table, name string, columns []string) error {
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", ")))
+	return err
+}
+
+func (PostgresDialect) Timestamp(column string) string {
+	return fmt.Sprintf(`to_char(%s, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`, column)
+}
+
+func (PostgresDialect) Datetime(column string) string {
+	return column
+}
+
+// Constraint matches the integrity constraint violation class of sqlstates the postgres drivers report
+func (PostgresDialect) Constraint(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && strings.HasPrefix(state.SQLState(), "23")
+}
+
+func (PostgresDialect) Shift(column string, seconds int) string {
+	return fmt.Sprintf("(%s + INTERVAL '%d seconds')", column, seconds)
+}
+
+var postgresPeriods = periodExpressions{
+	seconds: func(start, end string) string {
+		return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM (%s - %s)) AS BIGINT)", end, start)
+	},
+	months: func(start, end string) string {
+		return fmt.Sprintf("CAST((EXTRACT(YEAR FROM %[2]s) - EXTRACT(YEAR FROM %[1]s)) * 12 + EXTRACT(MONTH FROM %[2]s) - EXTRACT(MONTH FROM %[1]s) AS INTEGER)", start, end)
+	},
+	addMonths: func(start, months string) string {
+		// date_trunc gives the first of the month that make_interval moves and the interval of start into it
+		return fmt.Sprintf("(date_trunc('month', %[1]s) + make_interval(months => %[2]s) + (%[1]s - date_trunc('month', %[1]s)))", start, months)
+	},
+	floorDiv: func(value string, divisor int) string {
+		return fmt.Sprintf("((%s) / %d - CAST((%s) %% %d < 0 AS INTEGER))", value, divisor, value, divisor)
+	},
+	truth: func(condition string) string {
+		return fmt.Sprintf("CAST(%s AS INTEGER)", condition)
+	},
+}
+
+func (PostgresDialect) Bucket(period Period, start, end string) string {
+	return postgresPeriods.bucket(period, start, end)
+}
+
+// MySQLDialect stores data in MySQL 8 or later through the mysql driver
+type MySQLDialect struct{}
+
+func (MySQLDialect) Flavor() sqlbuilder.Flavor {
+	return sqlbuilder.MySQL
+}
+
+// DSN enables parseTime so datetimes scan like they do with the sqlite driver
+func (MySQLDialect) DSN(dsn string) string {
+	if strings.Contains(dsn, "parseTime=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&parseTime=true"
+	}
+	return dsn + "?parseTime=true"
+}
+
+// ColumnType stores text as longtext since mysql caps text columns at 64KB, short of cached cohorts and rejected rows
+func (MySQLDialect) ColumnType(definition string) string {
+	return strings.Replace(definition, "text", "longtext", 1)
+}
+
+func (MySQLDialect) Upsert(table string, columns []string, rows [][]interface{}) (string, []interface{}) {
+	return insertRows(sqlbuilder.MySQL.NewInsertBuilder().ReplaceInto(table), columns, rows).Build()
+}
+
+// CreateIndex looks the index up first as mysql has no CREATE INDEX IF NOT EXISTS
+func (MySQLDialect) CreateIndex(db Executor, table, name string, columns []string) error {
+	statement, args := sqlbuilder.Build(
+		"SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = $? AND index_name = $?",
+		table, name,
+	).BuildWithFlavor(sqlbuilder.MySQL)
+	if count, err := queryInt(db, statement, args...); err != nil || count != 0 {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, table, strings.Join(columns, ", ")))
+	return err
+}
+
+func (MySQLDialect) Timestamp(column string) string {
+	return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%dT%%H:%%i:%%sZ')", column)
+}
+
+func (MySQLDialect) Datetime(column string) string {
+	return column
+}
+
+// Constraint matches the integrity constraint violation sqlstate the mysql driver writes into its error messages as it is only built in with a tag
+func (MySQLDialect) Constraint(err error) bool {
+	return err != nil && strings.Contains(err.Error(), " (23000): ")
+}
+
+func (MySQLDialect) Shift(column string, seconds int) string {
+	return fmt.Sprintf("TIMESTAMPADD(SECOND, %d, %s)", seconds, column)
+}
+
+var mysqlPeriods = periodExpressions{
+	seconds: func(start, end string) string {
+		return fmt.Sprintf("TIMESTAMPDIFF(SECOND, %s, %s)", start, end)
+	},
+	months: func(start, end string) string {
+		return fmt.Sprintf("((YEAR(%[2]s) - YEAR(%[1]s)) * 12 + MONTH(%[2]s) - MONTH(%[1]s))", start, end)
+	},
+	addMonths: func(start, months string) string {
+		// DATE_FORMAT gives the first of the month that TIMESTAMPADD moves and TIMESTAMPDIFF the seconds of start into it
+		return fmt.Sprintf("TIMESTAMPADD(SECOND, TIMESTAMPDIFF(SECOND, DATE_FORMAT(%[1]s, '%%Y-%%m-01'), %[1]s), TIMESTAMPADD(MONTH, %[2]s, DATE_FORMAT(%[1]s, '%%Y-%%m-01')))", start, months)
+	},
+	floorDiv: func(value string, divisor int) string {
+		return fmt.Sprintf("((%s) DIV %d - ((%s) MOD %d < 0))", value, divisor, value, divisor)
+	},
+	truth: func(condition string) string {
+		return fmt.Sprintf("(%s)", condition)
+	},
+}
+
+func (MySQLDialect) Bucket(period Period, start, end string) string {
+	return mysqlPeriods.bucket(period, start, end)
+}
@@ -0,0 +1,90 @@
+package cohort
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDB records the statements run against it in place of a database of the given dialect
+type recordingDB struct {
+	dialect    Dialect
+	statements []string
+	args       [][]interface{}
+}
+
+func (db *recordingDB) Exec(statement string, args ...interface{}) (sql.Result, error) {
+	db.statements = append(db.statements, statement)
+	db.args = append(db.args, args)
+	return nil, nil
+}
+
+func (db *recordingDB) Query(statement string, args ...interface{}) (*sql.Rows, error) {
+	db.statements = append(db.statements, statement)
+	db.args = append(db.args, args)
+	return nil, errors.New("recorded")
+}
+
+func (db *recordingDB) Close() error {
+	return nil
+}
+
+func (db *recordingDB) Begin() (*sql.Tx, error) {
+	return nil, errors.New("recorded")
+}
+
+func (db *recordingDB) Dialect() Dialect {
+	return db.dialect
+}
+
+func TestDialects(t *testing.T) {
+	_, err := NewDialect("oracle")
+	assert.Error(t, err, "should fail on unknown drivers")
+	dialect, err := NewDialect("postgres")
+	assert.NoError(t, err, "should find dialects by driver")
+	assert.Equal(t, PostgresDialect{}, dialect, "should use the postgres dialect for postgres")
+	_, err = NewDialect("pgx")
+	assert.Error(t, err, "should fail on drivers without a vendored driver")
+
+	db := &recordingDB{dialect: PostgresDialect{}}
+	assert.NoError(t, CreateTable(db, "orders", map[string]string{"created": "datetime not null"}), "should create tables")
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS orders (created timestamp not null)", db.statements[0], "should translate column types")
+	assert.NoError(t, Insert(db, "customers", []string{"id", "created"}, []interface{}{1, "2015-06-19T23:49:32"}), "should insert rows")
+	assert.Equal(t, "INSERT INTO customers (id, created) VALUES ($1, $2)", db.statements[1], "should number placeholders")
+	assert.NoError(t, Upsert(db, "customers", []string{"id", "created"}, []interface{}{1, "2015-06-19T23:49:32"}), "should upsert rows")
+	assert.Equal(t, "INSERT INTO customers (id, created) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET created = EXCLUDED.created", db.statements[2], "should upsert on conflict")
+	_, err = Query(db, "customers", []string{"id"}, QueryOptions{Where: func(cond *sqlbuilder.Cond) string {
+		return cond.GreaterThan("created", "2015-06-19T00:00:00")
+	}, OrderBy: "created", Asc: true})
+	assert.Error(t, err, "should run queries")
+	assert.Equal(t, "SELECT id FROM customers WHERE created > $1 ORDER BY created ASC", db.statements[3], "should bind query args")
+	assert.Equal(t, []interface{}{"2015-06-19T00:00:00"}, db.args[3], "should pass query args")
+	_, err = queryCohort(db, time.Date(2015, 6, 19, 0, 0, 0, 0, time.UTC), time.Date(2015, 6, 26, 0, 0, 0, 0, time.UTC), DefaultConfig())
+	assert.Error(t, err, "should run cohort queries")
+	assert.Contains(t, db.statements[4], "created >= $1 AND created < $2", "should number cohort query placeholders")
+	assert.NotContains(t, db.statements[4], "strftime", "should not use sqlite functions")
+
+	statement, args := PostgresDialect{}.Upsert("customers", []string{"id", "created"}, [][]interface{}{{1, "a"}, {2, "b"}, {1, "c"}})
+	assert.Equal(t, "INSERT INTO customers (id, created) VALUES ($1, $2), ($3, $4) ON CONFLICT (id) DO UPDATE SET created = EXCLUDED.created", statement, "should upsert every key once")
+	assert.Equal(t, []interface{}{2, "b", 1, "c"}, args, "should keep the last row of a key")
+
+	db = &recordingDB{dialect: MySQLDialect{}}
+	Upsert(db, "customers", []string{"id", "created"}, []interface{}{1, "2015-06-19T23:49:32"})
+	assert.Equal(t, "REPLACE INTO customers (id, created) VALUES (?, ?)", db.statements[0], "should replace rows in mysql")
+	assert.Equal(t, "longtext not null", MySQLDialect{}.ColumnType(cohortCacheSchema["data"]), "should store cached cohorts beyond the mysql text limit")
+	assert.Equal(t, "user:pass@/cohorts?parseTime=true", MySQLDialect{}.DSN("user:pass@/cohorts"), "should parse mysql datetimes")
+	assert.Equal(t, "user:pass@/cohorts?tls=true&parseTime=false", MySQLDialect{}.DSN("user:pass@/cohorts?tls=true&parseTime=false"), "should keep explicit parseTime")
+
+	for _, dialect := range []Dialect{PostgresDialect{}, MySQLDialect{}} {
+		for _, period := range []Period{Week, Month} {
+			bucket := dialect.Bucket(period, "members.created", "orders.created")
+			assert.Equal(t, strings.Count(bucket, "("), strings.Count(bucket, ")"), "should balance bucket expression parentheses")
+			assert.NotContains(t, bucket, "strftime", "should not use sqlite functions in bucket expressions")
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cohort
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Engine defines where the orders of cohort customers are aggregated into buckets
+type Engine string
+
+// Engines supported when aggregating cohorts
+const (
+	// MemoryEngine streams the orders of every cohort customer into Go maps
+	MemoryEngine Engine = "memory"
+	// SQLEngine aggregates orders per customer and bucket with a single grouped query per cohort
+	SQLEngine Engine = "sql"
+)
+
+// ParseEngine returns the Engine matching value or an error if it is not supported
+func ParseEngine(value string) (Engine, error) {
+	switch engine := Engine(value); engine {
+	case MemoryEngine, SQLEngine:
+		return engine, nil
+	}
+	return "", fmt.Errorf("Unsupported engine %q expected one of sql or memory", value)
+}
+
+// zoneOffset is the offset from UTC in seconds a location observes from an instant on
+type zoneOffset struct {
+	from    time.Time
+	seconds int
+}
+
+// zoneOffsets returns the offsets location observes from from until until in order
+func zoneOffsets(location *time.Location, from, until time.Time) []zoneOffset {
+	offsets := []zoneOffset{}
+	t := from.In(location)
+	for t.Before(until) {
+		if _, seconds := t.Zone(); len(offsets) == 0 || offsets[len(offsets)-1].seconds != seconds {
+			offsets = append(offsets, zoneOffset{t, seconds})
+		}
+		_, next := t.ZoneBounds()
+		if next.IsZero() {
+			break
+		}
+		// zones extended by the rule of the timezone data can end where they start at the turn of a year
+		if !next.After(t) {
+			next = t.AddDate(0, 0, 1)
+		}
+		t = next
+	}
+	return offsets
+}
+
+// localTime returns an expression converting the UTC instants stored in column into wall clock datetimes of location, the offsets of location are spelled out as databases like sqlite have no timezone data
+func localTime(dialect Dialect, column string, location *time.Location) string {
+	offsets := zoneOffsets(location, time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if len(offsets) == 1 && offsets[0].seconds == 0 {
+		return column
+	}
+	return shiftTime(dialect, column, offsets)
+}
+
+// shiftTime shifts column by the offset in effect at its value, offsets are searched with nested CASE expressions so each value is compared against a logarithmic number of transitions
+func shiftTime(dialect Dialect, column string, offsets []zoneOffset) string {
+	if len(offsets) == 1 {
+		return dialect.Shift(column, offsets[0].seconds)
+	}
+	middle := len(offsets) / 2
+	return fmt.Sprintf("CASE WHEN %s < '%s' THEN %s ELSE %s END", dialect.Datetime(column), formatStored(offsets[middle].from), shiftTime(dialect, column, offsets[:middle]), shiftTime(dialect, column, offsets[middle:]))
+}
+
+// cohortQuery selects every customer matching the window condition along with the orders matching the orders condition and their order count, revenue and first order flag per bucket, customers without orders have null buckets, buckets are computed on the wall clock of location
+func cohortQuery(dialect Dialect, schema Schema, buckets bucketing, location *time.Location, window, orders Condition) (string, []interface{}) {
+	query := fmt.Sprintf(`WITH members AS (
+	SELECT %[1]s AS id, %[2]s AS created, %[8]s AS local_created FROM %[3]s WHERE $?
+), member_orders AS (
+	SELECT members.id AS user_id, members.local_created AS signup, %[9]s AS created, %[6]s AS amount, %[10]s AS currency
+	FROM members JOIN %[4]s AS orders ON $?
+), buckets AS (
+	SELECT user_id, %[5]s AS bucket, COUNT(*) AS orders, SUM(amount) AS revenue,
+		MIN(NULLIF(currency, '')) AS low_currency, MAX(NULLIF(currency, '')) AS high_currency
+	FROM member_orders
+	GROUP BY user_id, bucket
+)
+SELECT members.id, %[7]s, buckets.bucket, buckets.orders, buckets.revenue,
+	buckets.bucket = MIN(buckets.bucket) OVER (PARTITION BY members.id), buckets.low_currency, buckets.high_currency
+FROM members LEFT JOIN buckets ON buckets.user_id = members.id
+ORDER BY members.created, members.id, buckets.bucket`,
+		schema.CustomerID, schema.CustomerCreated, schema.CustomersTable, schema.OrdersTable,
+		buckets.expression(dialect, "member_orders.signup", "member_orders.created"), schema.orderAmount("orders"), dialect.Timestamp("members.created"),
+		localTime(dialect, schema.CustomerCreated, location), localTime(dialect, "orders."+schema.OrderCreated, location), schema.orderCurrency("orders"))
+	// orders join their member and must match the orders condition if any
+	join := func(cond *sqlbuilder.Cond) string {
+		return fmt.Sprintf("orders.%s = members.id", schema.OrderCustomer)
+	}
+	return sqlbuilder.Build(query, buildCondition(window), buildCondition(allOf(join, orders))).BuildWithFlavor(dialect.Flavor())
+}
+
+// queryCohort aggregates the orders of customers created between start and end in the database
+func queryCohort(db Executor, start, end time.Time, config Config) (Cohort, error) {
+	cohort := newCohort(start, end)
+	statement, args := cohortQuery(dialectOf(db), config.Schema, config.bucketing(), config.Location, config.customerWindow(dialectOf(db), start, end), config.OrderFilter.condition("orders"))
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return cohort, err
+	}
+	defer rows.Close()
+	var (
+		id      string
+		created string
+		bucket  sql.NullInt64
+		count   sql.NullInt64
+		revenue sql.NullFloat64
+		first   sql.NullBool
+		// the lowest and highest currency of the orders of a customer in a bucket
+		low  sql.NullString
+		high sql.NullString
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id, &created, &bucket, &count, &revenue, &first, &low, &high); err != nil {
+			return cohort, err
+		}
+		cohort.Customers[id] = parseStored(created, config.Location)
+		if !bucket.Valid {
+			continue
+		}
+		index := int(bucket.Int64)
+		orders, ok := cohort.Orders[index]
+		if !ok {
+			orders = Orders{
+				make(map[string]bool),
+				0,
+				0,
+				0,
+			}
+		}
+		orders.UniqueOrders[id] = true
+		orders.Count += int(count.Int64)
+		orders.Revenue += revenue.Float64
+		for _, currency := range []sql.NullString{low, high} {
+			if currency.Valid {
+				cohort.Currencies[currency.String] = true
+			}
+		}
+		// the earliest bucket of a customer holds their first order
+		if first.Bool {
+			cohort.HasOrder[id] = true
+			orders.FirstTimeOrders++
+		}
+		cohort.Orders[index] = orders
+		if cohort.MaxBucket < index {
+			cohort.MaxBucket = index
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return cohort, err
+	}
+	return cohort, nil
+}
@@ -0,0 +1,80 @@
+package cohort
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngines(t *testing.T) {
+	_, err := ParseEngine("spark")
+	assert.Error(t, err, "should fail on parsing unsupported engine")
+
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{1, "2015-01-31T23:00:00"},
+		{2, "2015-02-01T00:00:00"},
+		{3, "2015-02-03T12:00:00"},
+		{4, "2015-03-15T08:30:00"},
+		{5, "2015-03-20T10:00:00"},
+	}, [][]interface{}{
+		// order placed ahead of signup
+		{1, 1, 1, "2015-01-30T10:00:00", 5.0},
+		{2, 2, 1, "2015-02-28T23:00:00", 10.0},
+		{3, 3, 1, "2015-03-01T00:00:00", 12.5},
+		{4, 1, 2, "2015-02-08T00:00:00", 20.0},
+		{5, 2, 2, "2015-02-08T00:00:00", 7.25},
+		{6, 3, 2, "2015-05-01T00:00:00", 3.0},
+		{7, 1, 3, "2015-02-03T11:59:59", 1.5},
+		{8, 2, 3, "2015-02-10T12:00:00", 8.0},
+		{9, 1, 4, "2015-06-15T08:30:00", 40.0},
+		{10, 2, 4, "2016-03-15T08:29:59", 2.0},
+		// order placed less than a day ahead of signup
+		{11, 1, 5, "2015-03-19T22:00:00", 6.0},
+		{12, 2, 5, "2015-03-21T10:00:00", 4.0},
+	})
+	defer cleanup()
+
+	configs := []Config{DefaultConfig()}
+	for _, period := range []Period{Day, Week, Month, Quarter, Year} {
+		for _, mode := range []RetentionMode{Bracket, Unbounded, Cumulative} {
+			config := DefaultConfig()
+			config.CohortPeriod = Month
+			config.BucketPeriod = period
+			config.RetentionMode = mode
+			config.Metrics = MetricNames()
+			configs = append(configs, config)
+		}
+	}
+	irregular := DefaultConfig()
+	irregular.CohortPeriod = Month
+	irregular.Buckets = Buckets{0, 1, 7, 30, 90}
+	irregular.Metrics = MetricNames()
+	configs = append(configs, irregular)
+	for _, mode := range []RetentionMode{Bracket, Cumulative} {
+		firstOrder := DefaultConfig()
+		firstOrder.CohortBy = FirstOrderCohorts
+		firstOrder.CohortPeriod = Month
+		firstOrder.BucketPeriod = Day
+		firstOrder.RetentionMode = mode
+		firstOrder.Metrics = MetricNames()
+		configs = append(configs, firstOrder)
+	}
+	for _, config := range configs {
+		memory, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts in memory without error")
+		config.Engine = SQLEngine
+		database, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts in sql without error")
+
+		assert.Equal(t, memory.Headers, database.Headers, "should produce identical headers for "+string(config.BucketPeriod))
+		assert.Equal(t, memory.Rows, database.Rows, "should produce identical rows for "+string(config.BucketPeriod)+" "+string(config.RetentionMode))
+		assert.Equal(t, len(memory.Cohorts), len(database.Cohorts), "should produce identical cohorts")
+		for i := range memory.Cohorts {
+			assert.Equal(t, memory.Cohorts[i].Customers, database.Cohorts[i].Customers, "should find identical cohort customers")
+			assert.Equal(t, memory.Cohorts[i].HasOrder, database.Cohorts[i].HasOrder, "should find identical orderers")
+			assert.Equal(t, memory.Cohorts[i].Orders, database.Cohorts[i].Orders, "should aggregate identical orders")
+			assert.Equal(t, memory.Cohorts[i].MaxBucket, database.Cohorts[i].MaxBucket, "should reach identical buckets")
+		}
+	}
+}
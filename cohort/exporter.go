@@ -0,0 +1,96 @@
+package cohort
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Exporter writes computed cohorts to an output in a specific format
+type Exporter interface {
+	Export(output io.Writer, result Result) error
+}
+
+// ExporterFactory creates an Exporter for a single export
+type ExporterFactory func() Exporter
+
+var exporterRegistry = map[string]ExporterFactory{}
+
+// RegisterExporter makes an exporter selectable by format, registering an existing format replaces it
+func RegisterExporter(format string, factory ExporterFactory) {
+	exporterRegistry[format] = factory
+}
+
+// NewExporter creates the registered exporter for format
+func NewExporter(format string) (Exporter, error) {
+	factory, ok := exporterRegistry[format]
+	if !ok {
+		return nil, fmt.Errorf("Unknown format %q expected one of %v", format, ExporterFormats())
+	}
+	return factory(), nil
+}
+
+// ExporterFormats returns the sorted formats of all registered exporters
+func ExporterFormats() []string {
+	formats := make([]string, 0, len(exporterRegistry))
+	for format := range exporterRegistry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func init() {
+	RegisterExporter("csv", func() Exporter { return CSVExporter{} })
+	RegisterExporter("json", func() Exporter { return JSONExporter{} })
+	RegisterExporter("tidy", func() Exporter { return TidyExporter{} })
+	RegisterExporter("html", func() Exporter { return HeatmapExporter{true} })
+	RegisterExporter("svg", func() Exporter { return HeatmapExporter{false} })
+}
+
+// CSVExporter writes a header row followed by a row of formatted cells per cohort metric
+type CSVExporter struct {
+	writer *csv.Writer
+}
+
+// Open creates a new csv writer for w
+func (exporter CSVExporter) Open(w io.Writer) (CSVExporter, bool, error) {
+	writer := csv.NewWriter(w)
+	exporter.writer = writer
+	return exporter, true, nil
+}
+
+// Write writes a single csv row and flushes it to the underlying writer
+func (exporter CSVExporter) Write(row []string) error {
+	if err := exporter.writer.Write(row); err != nil {
+		return err
+	}
+	exporter.writer.Flush()
+	return exporter.writer.Error()
+}
+
+// Export writes the result header row followed by cohort rows
+func (exporter CSVExporter) Export(output io.Writer, result Result) error {
+	if exporter, ok, err := exporter.Open(output); ok {
+		for _, segment := range result.segments() {
+			// the matrix of every segment follows a row naming the segment column and value
+			if result.segmented() {
+				if err := exporter.Write([]string{segment.Config.SegmentBy, segment.Value}); err != nil {
+					return err
+				}
+			}
+			if err := exporter.Write(segment.Headers); err != nil {
+				return err
+			}
+			for _, row := range segment.Rows {
+				if err := exporter.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		return err
+	}
+	return nil
+}
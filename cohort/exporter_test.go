@@ -0,0 +1,107 @@
+package cohort
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestResult(t *testing.T) Result {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{33559, "2015-06-19T23:49:32"},
+		{33563, "2015-06-20T00:09:03"},
+		{33600, "2015-06-29T10:00:00"},
+	}, [][]interface{}{
+		{26444, 1, 33563, "2015-06-25T01:27:40", 10.0},
+		{26446, 2, 33563, "2015-07-04T10:00:00", 20.0},
+	})
+	defer cleanup()
+	result, err := Compute(context.Background(), db, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExporters(t *testing.T) {
+	_, err := NewExporter("xml")
+	assert.Error(t, err, "should fail on unknown format")
+	assert.Subset(t, ExporterFormats(), []string{"csv", "json", "tidy", "html", "svg"}, "should list registered formats")
+
+	result := makeTestResult(t)
+
+	exporter, err := NewExporter("csv")
+	assert.NoError(t, err, "should create csv exporter")
+	output := bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export csv without error")
+	assert.Equal(t, "Cohort,Customers,0-6,7-13,14-20\n"+
+		"06/26/2015-07/02/2015,1 customers,0% orderers (0),NA,NA\n"+
+		",,0% 1st time (0),NA,NA\n"+
+		"06/19/2015-06/25/2015,2 customers,50.00% orderers (1),0% orderers (0),50.00% orderers (1)\n"+
+		",,50.00% 1st time (1),0% 1st time (0),0% 1st time (0)\n", output.String(), "should write header and formatted rows with censored buckets as NA")
+
+	exporter, err = NewExporter("json")
+	assert.NoError(t, err, "should create json exporter")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export json without error")
+	structured := jsonResult{}
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &structured), "should write valid json")
+	assert.Equal(t, []string{"0-6", "7-13", "14-20"}, structured.Buckets, "should list bucket labels")
+	assert.Equal(t, 2, len(structured.Cohorts), "should write every cohort")
+	assert.Equal(t, "2015-06-19", structured.Cohorts[0].Start, "should write cohort start date")
+	assert.Equal(t, "2015-06-25", structured.Cohorts[0].End, "should write inclusive cohort end date")
+	assert.Equal(t, 2, structured.Cohorts[0].Customers, "should write cohort size")
+	assert.Equal(t, "2015-07-04T10:00:00Z", structured.ObservedUntil, "should write the date the data was observed until")
+	count, rate := float64(1), float64(0.5)
+	assert.Equal(t, jsonMetric{"orderers", &count, &rate}, structured.Cohorts[0].Buckets[0].Metrics[0], "should write counts and rates as numbers")
+	assert.Equal(t, jsonBucket{"14-20", true, []jsonMetric{{"orderers", nil, nil}, {"first_time", nil, nil}}}, structured.Cohorts[1].Buckets[2], "should write null counts and rates for censored buckets")
+
+	exporter, err = NewExporter("tidy")
+	assert.NoError(t, err, "should create tidy exporter")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export tidy csv without error")
+	rows, err := csv.NewReader(&output).ReadAll()
+	assert.NoError(t, err, "should write valid csv")
+	assert.Equal(t, 13, len(rows), "should write a row per cohort, bucket and metric")
+	assert.Equal(t, []string{"cohort_start", "cohort_end", "cohort_size", "bucket_start_day", "bucket_end_day", "metric", "count", "rate"}, rows[0], "should write tidy headers")
+	assert.Equal(t, []string{"2015-06-19", "2015-06-25", "2", "0", "6", "orderers", "1", "0.5"}, rows[1], "should write plain numbers")
+	assert.Equal(t, []string{"2015-06-26", "2015-07-02", "1", "14", "20", "orderers", "NA", "NA"}, rows[11], "should write censored buckets as NA")
+
+	exporter, err = NewExporter("svg")
+	assert.NoError(t, err, "should create svg exporter")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export svg without error")
+	decoder := xml.NewDecoder(&output)
+	rects := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err, "should write well formed svg") {
+			break
+		}
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "rect" {
+			rects++
+		}
+	}
+	assert.Equal(t, 7, rects, "should draw a cell per cohort bucket and a legend")
+
+	exporter, err = NewExporter("html")
+	assert.NoError(t, err, "should create html exporter")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export html without error")
+	assert.Contains(t, output.String(), "<title>06/19/2015-06/25/2015 0-6&#xA;50.00% orderers (1)&#xA;50.00% 1st time (1)</title>", "should include hover values of every metric")
+	assert.Contains(t, output.String(), "<title>06/26/2015-07/02/2015 14-20 not yet observed</title>", "should gray out censored buckets")
+	assert.Contains(t, output.String(), "2 customers", "should include cohort sizes")
+	assert.Contains(t, output.String(), "orderers rate", "should include a legend")
+	assert.Contains(t, output.String(), ">50.00%</text></g>", "should label cells with percentages like the csv rows")
+	assert.Contains(t, output.String(), "text-anchor=\"end\">50.00%</text>", "should label the legend with percentages")
+	assert.Equal(t, "20.00", heatmapLabel(&lifetimeValueMetric{}, 20), "should label rates of metrics without percentages as numbers")
+}
@@ -0,0 +1,560 @@
+package cohort
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Condition writes a sql condition with the methods of cond, values are bound as arguments of the statement holding the condition rather than written into it
+type Condition func(cond *sqlbuilder.Cond) string
+
+// allOf returns a condition holding when every condition holds, nil conditions are skipped
+func allOf(conditions ...Condition) Condition {
+	return func(cond *sqlbuilder.Cond) string {
+		expressions := []string{}
+		for _, condition := range conditions {
+			if condition != nil {
+				expressions = append(expressions, condition(cond))
+			}
+		}
+		return cond.And(expressions...)
+	}
+}
+
+// isNotNull returns a condition on column holding a value
+func isNotNull(column string) Condition {
+	return func(cond *sqlbuilder.Cond) string {
+		return cond.IsNotNull(column)
+	}
+}
+
+// conditionBuilder nests a condition in statements formatted with $? as a builder holding its own arguments
+type conditionBuilder struct {
+	args       *sqlbuilder.Args
+	expression string
+}
+
+// buildCondition returns a builder of condition
+func buildCondition(condition Condition) sqlbuilder.Builder {
+	cond := &sqlbuilder.Cond{Args: &sqlbuilder.Args{}}
+	return conditionBuilder{cond.Args, condition(cond)}
+}
+
+func (builder conditionBuilder) Build() (string, []interface{}) {
+	return builder.args.Compile(builder.expression)
+}
+
+func (builder conditionBuilder) BuildWithFlavor(flavor sqlbuilder.Flavor, initialArg ...interface{}) (string, []interface{}) {
+	return builder.args.CompileWithFlavor(builder.expression, flavor, initialArg...)
+}
+
+// Filter is a parsed filter expression restricting the rows of a table, the zero value filters nothing
+//
+// Expressions compare columns to quoted strings or numbers with = != <> < <= > >=, [not] like, [not] in (...), [not] between ... and ... or is [not] null, and combine comparisons with and, or, not and parentheses. Keywords are case insensitive and anything else is rejected, so values are always bound as arguments and columns are always plain identifiers.
+type Filter struct {
+	source string
+	root   filterNode
+}
+
+// ParseFilter parses a filter expression such as "country = 'US' and created >= '2015-01-01'"
+func ParseFilter(source string) (Filter, error) {
+	tokens, err := tokenizeFilter(source)
+	if err != nil {
+		return Filter{}, fmt.Errorf("Invalid filter %q %s", source, err.Error())
+	}
+	parser := filterParser{tokens: tokens}
+	root, err := parser.or()
+	if err == nil && !parser.done() {
+		err = parser.unexpected()
+	}
+	if err != nil {
+		return Filter{}, fmt.Errorf("Invalid filter %q %s", source, err.Error())
+	}
+	return Filter{source, root}, nil
+}
+
+// String returns the expression the filter was parsed from
+func (filter Filter) String() string {
+	return filter.source
+}
+
+// IsZero reports whether the filter filters nothing
+func (filter Filter) IsZero() bool {
+	return filter.root == nil
+}
+
+// condition returns the filter as a condition on columns qualified by table when it is set, or nil for the zero filter
+func (filter Filter) condition(table string) Condition {
+	if filter.root == nil {
+		return nil
+	}
+	return func(cond *sqlbuilder.Cond) string {
+		return filter.root.build(cond, table)
+	}
+}
+
+// columns returns the distinct columns the filter compares in the order they appear
+func (filter Filter) columns() []string {
+	columns := []string{}
+	if filter.root != nil {
+		filter.root.columns(&columns)
+	}
+	return columns
+}
+
+// stored returns filter with the datetimes compared to column parsed in location as the UTC instants datetimes are stored as
+func (filter Filter) stored(dialect Dialect, column string, location *time.Location) (Filter, error) {
+	if filter.root == nil {
+		return filter, nil
+	}
+	root, err := filter.root.stored(dialect, column, location)
+	if err != nil {
+		return filter, fmt.Errorf("Invalid filter %q %s", filter.source, err.Error())
+	}
+	return Filter{filter.source, root}, nil
+}
+
+// filterLayouts are the layouts of datetimes compared to datetime columns
+var filterLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+// parseFilterDatetime parses a datetime compared to a datetime column in location
+func parseFilterDatetime(value interface{}, location *time.Location) (interface{}, error) {
+	text, ok := value.(string)
+	if !ok {
+		// datetimes already parsed are kept as they are
+		if _, ok := value.(time.Time); ok {
+			return value, nil
+		}
+		return nil, fmt.Errorf("expected a quoted datetime in place of %v", value)
+	}
+	for _, layout := range filterLayouts {
+		if datetime, err := time.ParseInLocation(layout, text, location); err == nil {
+			return datetime, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a YYYY-MM-DD or YYYY-MM-DD HH:MM:SS datetime in place of %q", text)
+}
+
+// filterNode is a node of a parsed filter expression
+type filterNode interface {
+	build(cond *sqlbuilder.Cond, table string) string
+	columns(columns *[]string)
+	stored(dialect Dialect, column string, location *time.Location) (filterNode, error)
+}
+
+// logicalNode holds when all or any of its operands hold
+type logicalNode struct {
+	or       bool
+	operands []filterNode
+}
+
+func (node logicalNode) build(cond *sqlbuilder.Cond, table string) string {
+	expressions := make([]string, len(node.operands))
+	for i, operand := range node.operands {
+		expressions[i] = operand.build(cond, table)
+	}
+	if node.or {
+		return cond.Or(expressions...)
+	}
+	return cond.And(expressions...)
+}
+
+func (node logicalNode) columns(columns *[]string) {
+	for _, operand := range node.operands {
+		operand.columns(columns)
+	}
+}
+
+func (node logicalNode) stored(dialect Dialect, column string, location *time.Location) (filterNode, error) {
+	operands := make([]filterNode, len(node.operands))
+	for i, operand := range node.operands {
+		var err error
+		if operands[i], err = operand.stored(dialect, column, location); err != nil {
+			return nil, err
+		}
+	}
+	return logicalNode{node.or, operands}, nil
+}
+
+// notNode holds when its operand does not
+type notNode struct {
+	operand filterNode
+}
+
+func (node notNode) build(cond *sqlbuilder.Cond, table string) string {
+	return fmt.Sprintf("NOT (%s)", node.operand.build(cond, table))
+}
+
+func (node notNode) columns(columns *[]string) {
+	node.operand.columns(columns)
+}
+
+func (node notNode) stored(dialect Dialect, column string, location *time.Location) (filterNode, error) {
+	operand, err := node.operand.stored(dialect, column, location)
+	return notNode{operand}, err
+}
+
+// comparisonNode compares a column to its values with one of the operators of filter expressions
+type comparisonNode struct {
+	column   string
+	operator string
+	values   []interface{}
+	// datetime normalizes the compared column once its values are parsed datetimes
+	datetime Dialect
+}
+
+func (node comparisonNode) build(cond *sqlbuilder.Cond, table string) string {
+	column := node.column
+	if table != "" {
+		column = table + "." + column
+	}
+	if node.datetime != nil {
+		column = node.datetime.Datetime(column)
+	}
+	values := make([]interface{}, len(node.values))
+	for i, value := range node.values {
+		if datetime, ok := value.(time.Time); ok {
+			value = formatStored(datetime)
+		}
+		values[i] = value
+	}
+	switch node.operator {
+	case "=":
+		return cond.Equal(column, values[0])
+	case "!=", "<>":
+		return cond.NotEqual(column, values[0])
+	case "<":
+		return cond.LessThan(column, values[0])
+	case "<=":
+		return cond.LessEqualThan(column, values[0])
+	case ">":
+		return cond.GreaterThan(column, values[0])
+	case ">=":
+		return cond.GreaterEqualThan(column, values[0])
+	case "like":
+		return cond.Like(column, values[0])
+	case "not like":
+		return cond.NotLike(column, values[0])
+	case "in":
+		return cond.In(column, values...)
+	case "not in":
+		return cond.NotIn(column, values...)
+	case "between":
+		return cond.Between(column, values[0], values[1])
+	case "not between":
+		return cond.NotBetween(column, values[0], values[1])
+	case "is null":
+		return cond.IsNull(column)
+	}
+	return cond.IsNotNull(column)
+}
+
+func (node comparisonNode) columns(columns *[]string) {
+	for _, column := range *columns {
+		if column == node.column {
+			return
+		}
+	}
+	*columns = append(*columns, node.column)
+}
+
+func (node comparisonNode) stored(dialect Dialect, column string, location *time.Location) (filterNode, error) {
+	// like patterns match the stored text of datetimes
+	if node.column != column || node.operator == "like" || node.operator == "not like" {
+		return node, nil
+	}
+	values := make([]interface{}, len(node.values))
+	for i, value := range node.values {
+		var err error
+		if values[i], err = parseFilterDatetime(value, location); err != nil {
+			return nil, fmt.Errorf("compares column %s %s", column, err.Error())
+		}
+	}
+	node.values, node.datetime = values, dialect
+	return node, nil
+}
+
+// filterTokenKind classifies the tokens of filter expressions
+type filterTokenKind int
+
+const (
+	identifierToken filterTokenKind = iota
+	keywordToken
+	stringToken
+	numberToken
+	operatorToken
+	punctuationToken
+)
+
+// filterToken is a token of a filter expression along with its position
+type filterToken struct {
+	kind     filterTokenKind
+	text     string
+	value    interface{}
+	position int
+}
+
+// filterKeywords are the keywords of filter expressions, they cannot name columns
+var filterKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true, "null": true, "like": true, "between": true,
+}
+
+// tokenizeFilter splits a filter expression into tokens, rejecting any character outside of the expression language
+func tokenizeFilter(source string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	isLetter := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(source); {
+		c := source[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c):
+			for i < len(source) && (isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			word := source[start:i]
+			if keyword := strings.ToLower(word); filterKeywords[keyword] {
+				tokens = append(tokens, filterToken{keywordToken, keyword, nil, start})
+			} else {
+				tokens = append(tokens, filterToken{identifierToken, word, nil, start})
+			}
+		case c == '\'':
+			// quotes are escaped by doubling them
+			value := strings.Builder{}
+			i++
+			for {
+				if i == len(source) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+				if source[i] == '\'' {
+					if i+1 < len(source) && source[i+1] == '\'' {
+						value.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(source[i])
+				i++
+			}
+			tokens = append(tokens, filterToken{stringToken, source[start:i], value.String(), start})
+		case isDigit(c) || c == '-' && i+1 < len(source) && isDigit(source[i+1]):
+			i++
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			text := source[start:i]
+			var value interface{}
+			var err error
+			if strings.Contains(text, ".") {
+				value, err = strconv.ParseFloat(text, 64)
+			} else {
+				value, err = strconv.ParseInt(text, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", text, start)
+			}
+			tokens = append(tokens, filterToken{numberToken, text, value, start})
+		case c == '(' || c == ')' || c == ',':
+			i++
+			tokens = append(tokens, filterToken{punctuationToken, source[start:i], nil, start})
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			operator := source[start : start+1]
+			if i+1 < len(source) {
+				if two := source[start : start+2]; two == "<=" || two == ">=" || two == "!=" || two == "<>" {
+					operator = two
+				}
+			}
+			if operator == "!" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, start)
+			}
+			i += len(operator)
+			tokens = append(tokens, filterToken{operatorToken, operator, nil, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, start)
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser parses filter tokens by recursive descent, or binds looser than and, which binds looser than not
+type filterParser struct {
+	tokens   []filterToken
+	position int
+}
+
+func (parser *filterParser) done() bool {
+	return parser.position == len(parser.tokens)
+}
+
+// peek reports whether the next token is of kind and spelled text
+func (parser *filterParser) peek(kind filterTokenKind, text string) bool {
+	return !parser.done() && parser.tokens[parser.position].kind == kind && parser.tokens[parser.position].text == text
+}
+
+// accept consumes the next token if it is of kind and spelled text
+func (parser *filterParser) accept(kind filterTokenKind, text string) bool {
+	if parser.peek(kind, text) {
+		parser.position++
+		return true
+	}
+	return false
+}
+
+func (parser *filterParser) expect(kind filterTokenKind, text string) error {
+	if !parser.accept(kind, text) {
+		return parser.unexpected()
+	}
+	return nil
+}
+
+func (parser *filterParser) unexpected() error {
+	if parser.done() {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	token := parser.tokens[parser.position]
+	return fmt.Errorf("unexpected %s at %d", token.text, token.position)
+}
+
+func (parser *filterParser) or() (filterNode, error) {
+	return parser.logical(true, parser.and)
+}
+
+func (parser *filterParser) and() (filterNode, error) {
+	return parser.logical(false, parser.not)
+}
+
+// logical parses operands separated by the or or the and keyword
+func (parser *filterParser) logical(or bool, operand func() (filterNode, error)) (filterNode, error) {
+	keyword := "and"
+	if or {
+		keyword = "or"
+	}
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []filterNode{first}
+	for parser.accept(keywordToken, keyword) {
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return logicalNode{or, operands}, nil
+}
+
+func (parser *filterParser) not() (filterNode, error) {
+	if parser.accept(keywordToken, "not") {
+		operand, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	if parser.accept(punctuationToken, "(") {
+		node, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, parser.expect(punctuationToken, ")")
+	}
+	return parser.comparison()
+}
+
+func (parser *filterParser) comparison() (filterNode, error) {
+	if parser.done() || parser.tokens[parser.position].kind != identifierToken {
+		return nil, parser.unexpected()
+	}
+	column := parser.tokens[parser.position].text
+	parser.position++
+	if parser.done() {
+		return nil, parser.unexpected()
+	}
+	if token := parser.tokens[parser.position]; token.kind == operatorToken {
+		parser.position++
+		value, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonNode{column, token.text, []interface{}{value}, nil}, nil
+	}
+	if parser.accept(keywordToken, "is") {
+		operator := "is null"
+		if parser.accept(keywordToken, "not") {
+			operator = "is not null"
+		}
+		return comparisonNode{column, operator, nil, nil}, parser.expect(keywordToken, "null")
+	}
+	negated := parser.accept(keywordToken, "not")
+	prefix := ""
+	if negated {
+		prefix = "not "
+	}
+	switch {
+	case parser.accept(keywordToken, "like"):
+		value, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonNode{column, prefix + "like", []interface{}{value}, nil}, nil
+	case parser.accept(keywordToken, "in"):
+		if err := parser.expect(punctuationToken, "("); err != nil {
+			return nil, err
+		}
+		values := []interface{}{}
+		for {
+			value, err := parser.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !parser.accept(punctuationToken, ",") {
+				break
+			}
+		}
+		return comparisonNode{column, prefix + "in", values, nil}, parser.expect(punctuationToken, ")")
+	case parser.accept(keywordToken, "between"):
+		lower, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := parser.expect(keywordToken, "and"); err != nil {
+			return nil, err
+		}
+		upper, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonNode{column, prefix + "between", []interface{}{lower, upper}, nil}, nil
+	}
+	return nil, parser.unexpected()
+}
+
+// value parses a quoted string or a number
+func (parser *filterParser) value() (interface{}, error) {
+	if parser.done() {
+		return nil, parser.unexpected()
+	}
+	token := parser.tokens[parser.position]
+	if token.kind != stringToken && token.kind != numberToken {
+		return nil, parser.unexpected()
+	}
+	parser.position++
+	return token.value, nil
+}
@@ -0,0 +1,119 @@
+package cohort
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter("name = 'O''Brien' OR country in ('US', 'UK') and not (amount <= 10.5 or plan is null)")
+	assert.NoError(t, err, "should parse valid filters")
+	statement, args := buildCondition(filter.condition("")).Build()
+	assert.Equal(t, "(name = ? OR (country IN (?, ?) AND NOT ((amount <= ? OR plan IS NULL))))", statement, "should bind every value and give and precedence over or")
+	assert.Equal(t, []interface{}{"O'Brien", "US", "UK", 10.5}, args, "should unescape quoted values")
+	assert.Equal(t, []string{"name", "country", "amount", "plan"}, filter.columns(), "should list compared columns")
+
+	filter, err = ParseFilter("amount between -1 and 10 and currency not like 'U%' and plan is not null and id != 3")
+	assert.NoError(t, err, "should parse every operator")
+	statement, args = buildCondition(filter.condition("orders")).Build()
+	assert.Equal(t, "(orders.amount BETWEEN ? AND ? AND orders.currency NOT LIKE ? AND orders.plan IS NOT NULL AND orders.id <> ?)", statement, "should qualify columns with the table")
+	assert.Equal(t, []interface{}{int64(-1), int64(10), "U%", int64(3)}, args, "should bind numbers")
+
+	filter, _ = ParseFilter("created >= '2015-06-21' and created < '2015-06-22 12:00:00'")
+	filter, err = filter.stored(SQLiteDialect{}, "created", time.FixedZone("EDT", -4*60*60))
+	assert.NoError(t, err, "should parse datetimes compared to the created column")
+	statement, args = buildCondition(filter.condition("")).Build()
+	assert.Equal(t, "(strftime('%Y-%m-%dT%H:%M:%S', created) >= ? AND strftime('%Y-%m-%dT%H:%M:%S', created) < ?)", statement, "should compare datetimes in the stored layout")
+	assert.Equal(t, []interface{}{"2015-06-21T04:00:00", "2015-06-22T16:00:00"}, args, "should bind datetimes as stored UTC instants")
+	_, err = ParseFilter("created >= 'yesterday'")
+	assert.NoError(t, err, "should parse datetimes as strings")
+	filter, _ = ParseFilter("created >= 'yesterday'")
+	_, err = filter.stored(SQLiteDialect{}, "created", time.UTC)
+	assert.Error(t, err, "should reject invalid datetimes compared to the created column")
+
+	for _, source := range []string{
+		"country = 'US'; DROP TABLE customers",
+		"country = 'US' -- and plan = 'pro'",
+		"country = 'US' /* */",
+		"1 = 1",
+		"country = 'US' or 1 = 1",
+		"country = 'US",
+		"country = US",
+		"customers.country = 'US'",
+		"country = 'US' union select 1",
+		"country = ('US')",
+		"country in ()",
+		"country = \"US\"",
+		"country == 'US'",
+		"",
+	} {
+		_, err := ParseFilter(source)
+		assert.Error(t, err, "should reject "+source)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	db, cleanup := makeTestSource(t, nil, [][]interface{}{
+		{1, 1, 1, "2015-06-21T10:00:00", 5.0},
+		{2, 2, 1, "2015-06-30T10:00:00", 20.0},
+		{3, 1, 2, "2015-06-22T10:00:00", 20.0},
+		{4, 1, 3, "2015-06-23T10:00:00", 8.0},
+	})
+	defer cleanup()
+	config := DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	customers := writeTestCSV(t, "id,created,country\n1,2015-06-19 23:49:32,US\n2,2015-06-20 00:09:03,UK\n3,2015-06-21 00:09:03,US\n4,2015-06-27 10:00:00,US\n")
+	defer os.Remove(customers)
+	_, err := ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should import customers")
+
+	total := func(result Result) (int, int) {
+		customers, orders := 0, 0
+		for _, cohort := range result.Cohorts {
+			customers += len(cohort.Customers)
+			for _, bucket := range cohort.Orders {
+				orders += bucket.Count
+			}
+		}
+		return customers, orders
+	}
+	rows := map[Engine][][]string{}
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		config.CustomerFilter, _ = ParseFilter("country = 'US' and created >= '2015-06-21'")
+		config.OrderFilter = Filter{}
+		result, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute filtered cohorts without error")
+		customers, orders := total(result)
+		assert.Equal(t, 2, customers, "should restrict cohorts to filtered customers")
+		assert.Equal(t, 1, orders, "should aggregate the orders of filtered customers")
+
+		config.Location, _ = time.LoadLocation("America/New_York")
+		result, err = Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute filtered cohorts in a timezone without error")
+		customers, _ = total(result)
+		assert.Equal(t, 1, customers, "should read filter datetimes in the timezone")
+		config.Location = time.UTC
+
+		config.CustomerFilter = Filter{}
+		config.OrderFilter, _ = ParseFilter("amount > 10")
+		result, err = Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts of filtered orders without error")
+		customers, orders = total(result)
+		assert.Equal(t, 4, customers, "should keep customers without filtered orders")
+		assert.Equal(t, 2, orders, "should restrict buckets to filtered orders")
+		rows[engine] = result.Rows
+	}
+	assert.Equal(t, rows[MemoryEngine], rows[SQLEngine], "should filter orders alike with both engines")
+
+	config.CustomerFilter, _ = ParseFilter("created >= 'yesterday'")
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on invalid filter datetimes")
+	config.CustomerFilter, _ = ParseFilter("missing = 'US'")
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on filter columns missing from the customers table")
+}
@@ -0,0 +1,193 @@
+package cohort
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HeatmapExporter renders the cohort by bucket matrix as a heatmap colored by the rate of the first metric
+type HeatmapExporter struct {
+	// HTML wraps the svg heatmap in a standalone html page
+	HTML bool
+}
+
+const (
+	heatmapLabelWidth  = 170
+	heatmapSizeWidth   = 100
+	heatmapCellWidth   = 60
+	heatmapCellHeight  = 24
+	heatmapLegendWidth = 240
+	// heatmapCensoredColor fills the cells of censored buckets
+	heatmapCensoredColor = "#e0e0e0"
+)
+
+// heatmapColor interpolates between a light and a dark blue for a fraction between 0 and 1
+func heatmapColor(fraction float64) string {
+	light := [3]float64{247, 251, 255}
+	dark := [3]float64{8, 48, 107}
+	color := [3]int{}
+	for i := range color {
+		color[i] = int(light[i] + (dark[i]-light[i])*fraction)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", color[0], color[1], color[2])
+}
+
+// escape returns value escaped for use in xml text and attributes
+func escape(value string) string {
+	escaped := strings.Builder{}
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
+
+// heatmapRoot holds the attributes of the outermost svg element
+const heatmapRoot = `xmlns="http://www.w3.org/2000/svg" `
+
+// heatmapLabel formats rate like the csv cells of metric, as a percentage when they hold percentages
+func heatmapLabel(metric Metric, rate float64) string {
+	if strings.Contains(metric.Format(Value{}), "%") {
+		return fmt.Sprintf("%.2f%%", rate*100)
+	}
+	return fmt.Sprintf("%.2f", rate)
+}
+
+// heatmapDefs defines the legend gradient once in the outermost svg element so nested heatmaps share its id
+func heatmapDefs() string {
+	return fmt.Sprintf("<defs><linearGradient id=\"legend\"><stop offset=\"0\" stop-color=\"%s\"/><stop offset=\"1\" stop-color=\"%s\"/></linearGradient></defs>\n", heatmapColor(0), heatmapColor(1))
+}
+
+// heatmapSize returns the width and height of the heatmap of result
+func heatmapSize(result Result) (int, int) {
+	width := heatmapLabelWidth + heatmapSizeWidth + heatmapCellWidth*len(result.Buckets)
+	if width < heatmapLabelWidth+heatmapLegendWidth+heatmapCellWidth {
+		width = heatmapLabelWidth + heatmapLegendWidth + heatmapCellWidth
+	}
+	return width, heatmapCellHeight * (len(result.Cohorts) + 4)
+}
+
+// renderSVG renders result as an svg element holding the given attributes, nested heatmaps are positioned by them
+func (exporter HeatmapExporter) renderSVG(result Result, attributes string) string {
+	svg := strings.Builder{}
+	// color cells relative to the highest observed rate of the first metric across all cohorts
+	var metric Metric
+	maxRate := float64(0)
+	for _, cohort := range result.Cohorts {
+		if len(cohort.Metrics) == 0 {
+			continue
+		}
+		metric = cohort.Metrics[0].Metric
+		for bucket, value := range cohort.Metrics[0].Values {
+			if !cohort.Censored(bucket) && value.Rate > maxRate {
+				maxRate = value.Rate
+			}
+		}
+	}
+	fraction := func(rate float64) float64 {
+		if maxRate == 0 {
+			return 0
+		}
+		return rate / maxRate
+	}
+
+	gridHeight := heatmapCellHeight * (len(result.Cohorts) + 1)
+	width, height := heatmapSize(result)
+	fmt.Fprintf(&svg, "<svg %swidth=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"11\">\n", attributes, width, height)
+	if attributes == heatmapRoot {
+		svg.WriteString(heatmapDefs())
+	}
+
+	// header row of column labels
+	fmt.Fprintf(&svg, "<text x=\"4\" y=\"16\" font-weight=\"bold\">Cohort</text>\n")
+	fmt.Fprintf(&svg, "<text x=\"%d\" y=\"16\" font-weight=\"bold\">Customers</text>\n", heatmapLabelWidth+4)
+	for bucket, label := range result.Buckets {
+		fmt.Fprintf(&svg, "<text x=\"%d\" y=\"16\" text-anchor=\"middle\" font-weight=\"bold\">%s</text>\n", heatmapLabelWidth+heatmapSizeWidth+bucket*heatmapCellWidth+heatmapCellWidth/2, escape(label))
+	}
+
+	// a row per cohort in chronological order with a cell per bucket of the result
+	for row, cohort := range result.Cohorts {
+		y := (row + 1) * heatmapCellHeight
+		fmt.Fprintf(&svg, "<text x=\"4\" y=\"%d\">%s</text>\n", y+16, escape(cohort.Dates))
+		fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%d\">%d customers</text>\n", heatmapLabelWidth+4, y+16, len(cohort.Customers))
+		if len(cohort.Metrics) == 0 {
+			continue
+		}
+		for bucket, value := range cohort.Metrics[0].Values {
+			x := heatmapLabelWidth + heatmapSizeWidth + bucket*heatmapCellWidth
+			// censored buckets are grayed out as their values are missing time rather than churn
+			if cohort.Censored(bucket) {
+				fmt.Fprintf(&svg, "<g><title>%s</title>", escape(fmt.Sprintf("%s %s not yet observed", cohort.Dates, result.Buckets[bucket])))
+				fmt.Fprintf(&svg, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\" stroke=\"#ffffff\"/>", x, y, heatmapCellWidth, heatmapCellHeight, heatmapCensoredColor)
+				fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" fill=\"#888888\">%s</text></g>\n", x+heatmapCellWidth/2, y+16, CensoredCell)
+				continue
+			}
+			// hover text lists the formatted value of every metric for the cell
+			hover := []string{fmt.Sprintf("%s %s", cohort.Dates, result.Buckets[bucket])}
+			for _, values := range cohort.Metrics {
+				hover = append(hover, values.Metric.Format(values.Values[bucket]))
+			}
+			textColor := "#000000"
+			if fraction(value.Rate) > 0.5 {
+				textColor = "#ffffff"
+			}
+			fmt.Fprintf(&svg, "<g><title>%s</title>", escape(strings.Join(hover, "\n")))
+			fmt.Fprintf(&svg, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\" stroke=\"#ffffff\"/>", x, y, heatmapCellWidth, heatmapCellHeight, heatmapColor(fraction(value.Rate)))
+			fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" fill=\"%s\">%s</text></g>\n", x+heatmapCellWidth/2, y+16, textColor, heatmapLabel(cohort.Metrics[0].Metric, value.Rate))
+		}
+	}
+
+	// legend mapping colors to the rate of the first metric
+	metricName, minLabel, maxLabel := "", "0", "0"
+	if metric != nil {
+		metricName, minLabel, maxLabel = metric.Name(), heatmapLabel(metric, 0), heatmapLabel(metric, maxRate)
+	}
+	legendY := gridHeight + heatmapCellHeight
+	fmt.Fprintf(&svg, "<text x=\"4\" y=\"%d\">%s rate</text>\n", legendY+16, escape(metricName))
+	fmt.Fprintf(&svg, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"url(#legend)\" stroke=\"#cccccc\"/>\n", heatmapLabelWidth, legendY, heatmapLegendWidth, heatmapCellHeight)
+	fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%d\">%s</text>\n", heatmapLabelWidth, legendY+heatmapCellHeight+14, minLabel)
+	fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%s</text>\n", heatmapLabelWidth+heatmapLegendWidth, legendY+heatmapCellHeight+14, maxLabel)
+	svg.WriteString("</svg>\n")
+	return svg.String()
+}
+
+// renderSegments stacks the heatmap of every segment of result under a label naming its value
+func (exporter HeatmapExporter) renderSegments(result Result) string {
+	width, height := 0, 0
+	for _, segment := range result.Segments {
+		segmentWidth, segmentHeight := heatmapSize(segment.Result)
+		if segmentWidth > width {
+			width = segmentWidth
+		}
+		height += heatmapCellHeight + segmentHeight
+	}
+	svg := strings.Builder{}
+	fmt.Fprintf(&svg, "<svg %swidth=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"11\">\n", heatmapRoot, width, height)
+	svg.WriteString(heatmapDefs())
+	y := 0
+	for _, segment := range result.Segments {
+		fmt.Fprintf(&svg, "<text x=\"4\" y=\"%d\" font-weight=\"bold\">%s</text>\n", y+16, escape(fmt.Sprintf("%s = %s", result.Config.SegmentBy, segment.Value)))
+		y += heatmapCellHeight
+		svg.WriteString(exporter.renderSVG(segment.Result, fmt.Sprintf("y=\"%d\" ", y)))
+		_, segmentHeight := heatmapSize(segment.Result)
+		y += segmentHeight
+	}
+	svg.WriteString("</svg>\n")
+	return svg.String()
+}
+
+// Export writes the heatmap as a standalone svg image or html page
+func (exporter HeatmapExporter) Export(output io.Writer, result Result) error {
+	var svg string
+	// segmented results stack a heatmap per segment
+	if result.segmented() {
+		svg = exporter.renderSegments(result)
+	} else {
+		svg = exporter.renderSVG(result, heatmapRoot)
+	}
+	if !exporter.HTML {
+		_, err := io.WriteString(output, xml.Header+svg)
+		return err
+	}
+	_, err := fmt.Fprintf(output, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Cohort analysis</title>\n</head>\n<body>\n%s</body>\n</html>\n", svg)
+	return err
+}
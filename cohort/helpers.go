@@ -1,4 +1,4 @@
-package main
+package cohort
 
 // OrderedStringSet ensures that duplicate string values can't be inserted and the order of those insertions is preserved
 type OrderedStringSet struct {
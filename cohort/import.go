@@ -0,0 +1,400 @@
+package cohort
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var customerSchema = map[string]string{
+	"id":      "int not null primary key",
+	"created": "datetime not null",
+}
+
+var orderSchema = map[string]string{
+	"id":           "int not null primary key",
+	"order_number": "int not null",
+	"user_id":      "int not null",
+	"created":      "datetime not null",
+	"amount":       "real not null default 0",
+	"currency":     "varchar(3) not null default ''",
+}
+
+// tables lists every table created by MakeTables
+var tables = []string{"customers", "orders", "imports", "changes", "cohort_cache", "rejects"}
+
+// MakeTables creates the customers and orders tables cohorts are computed from along with incremental import metadata
+func MakeTables(db SQL) error {
+	if err := CreateTable(db, "customers", customerSchema); err != nil {
+		return fmt.Errorf("Failed to create customers table with error %s", err.Error())
+	}
+
+	if err := CreateTable(db, "orders", orderSchema); err != nil {
+		return fmt.Errorf("Failed to create orders table with error %s", err.Error())
+	}
+
+	// index the columns cohorts are windowed and joined on
+	if err := CreateIndex(db, "customers", "created"); err != nil {
+		return fmt.Errorf("Failed to index customers table with error %s", err.Error())
+	}
+
+	if err := CreateIndex(db, "orders", "user_id", "created"); err != nil {
+		return fmt.Errorf("Failed to index orders table with error %s", err.Error())
+	}
+
+	if err := makeIncrementalTables(db); err != nil {
+		return err
+	}
+
+	if err := CreateTable(db, "rejects", rejectSchema); err != nil {
+		return fmt.Errorf("Failed to create rejects table with error %s", err.Error())
+	}
+
+	return nil
+}
+
+func makeCustomerImportTransformer(config Config) ImportTransformer {
+	mapping := config.columns()
+	timestamps := config.timestamps(config.CustomerTimestamps)
+	return func(headers, line []string) (map[string]interface{}, error) {
+		customer := make(map[string]interface{})
+		var err error
+		if customer["id"], err = parseInt("customer_id", headerValue(headers, line, mapping.CustomerID)); err != nil {
+			return nil, err
+		}
+		if customer["created"], err = parseDatetime("signup_at", headerValue(headers, line, mapping.SignupAt), timestamps); err != nil {
+			return nil, err
+		}
+		return customer, nil
+	}
+}
+
+// headerIndex returns the position of the named column in headers or -1 if it is missing
+func headerIndex(headers []string, name string) int {
+	for i, header := range headers {
+		if header == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// headerValue returns the value of the named column in line or an empty string if it is missing
+func headerValue(headers, line []string, name string) string {
+	if name == "" {
+		return ""
+	}
+	if i := headerIndex(headers, name); i != -1 {
+		return line[i]
+	}
+	return ""
+}
+
+// parseInt parses the value of an integer field
+func parseInt(field, value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, FieldError{field, value}
+	}
+	return number, nil
+}
+
+// parseDatetime parses the value of a datetime field into the UTC instant it is stored as
+func parseDatetime(field, value string, timestamps TimestampParser) (string, error) {
+	datetime, err := timestamps.Parse(value)
+	if err != nil {
+		return "", FieldError{field, value}
+	}
+	return formatStored(datetime), nil
+}
+
+func makeOrderImportTransformer(config Config) ImportTransformer {
+	mapping := config.columns()
+	timestamps := config.timestamps(config.OrderTimestamps)
+	return func(headers, line []string) (map[string]interface{}, error) {
+		order := make(map[string]interface{})
+		var err error
+		if order["id"], err = parseInt("order_id", headerValue(headers, line, mapping.OrderID)); err != nil {
+			return nil, err
+		}
+		if order["user_id"], err = parseInt("order_user_id", headerValue(headers, line, mapping.OrderUserID)); err != nil {
+			return nil, err
+		}
+		// order number, amount and currency are optional columns
+		order["order_number"] = 0
+		if value := headerValue(headers, line, mapping.OrderNumber); value != "" {
+			if order["order_number"], err = parseInt("order_number", value); err != nil {
+				return nil, err
+			}
+		}
+		order["amount"] = float64(0)
+		if value := headerValue(headers, line, mapping.Amount); value != "" {
+			if order["amount"], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, FieldError{"amount", value}
+			}
+		}
+		order["currency"] = headerValue(headers, line, mapping.Currency)
+		if order["created"], err = parseDatetime("ordered_at", headerValue(headers, line, mapping.OrderedAt), timestamps); err != nil {
+			return nil, err
+		}
+		return order, nil
+	}
+}
+
+// importTable describes how the rows of an imported csv are stored
+type importTable struct {
+	name    string
+	columns []string
+	// customerColumn names the column identifying the customer a row belongs to
+	customerColumn string
+	// headers returns the csv headers required to import the table with a column mapping
+	headers func(ColumnMapping) []string
+	// attributes stores every unmapped csv header in a column of the table named after it
+	attributes bool
+}
+
+var customersTable = importTable{"customers", []string{"id", "created"}, "id", ColumnMapping.customerHeaders, true}
+
+var ordersTable = importTable{"orders", []string{"id", "order_number", "user_id", "created", "amount", "currency"}, "user_id", ColumnMapping.orderHeaders, false}
+
+// importCSV stores the rows of csv data read from input after skipping the first skip rows in a single transaction, counting accepted and rejected rows into report, and returns the number of rows read
+func importCSV(db SQL, input io.Reader, report *ImportReport, table importTable, transformer ImportTransformer, config Config, skip int) (int, error) {
+	importer, ok, err := NewImporter().Require(table.headers(config.columns())...).OpenReader(input)
+	if !ok {
+		return 0, err
+	}
+	// attribute columns are added ahead of the transaction as databases like mysql commit schema changes implicitly
+	columns, attributeIndexes := table.columns, []int{}
+	if table.attributes {
+		var attributes []string
+		if attributes, attributeIndexes, err = attributeColumns(db, table, importer.headers, config.columns()); err != nil {
+			return 0, err
+		}
+		columns = append(append([]string{}, table.columns...), attributes...)
+	}
+	tx, err := begin(db)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := importRows(tx, importer, columns, attributeIndexes, report, table, transformer, config, skip)
+	if err != nil {
+		tx.Rollback()
+		return rows, err
+	}
+	return rows, tx.Commit()
+}
+
+// bufferedRow is a csv row buffered in a batch along with its line and values
+type bufferedRow struct {
+	line   int
+	row    []string
+	values []interface{}
+}
+
+// importRows writes the csv rows read by importer to the columns of table within tx in batches of multi-row statements, the values of attribute columns are read from the attribute indexes of rows
+func importRows(tx transaction, importer Importer, columns []string, attributeIndexes []int, report *ImportReport, table importTable, transformer ImportTransformer, config Config, skip int) (int, error) {
+	rows := 0
+	generation := 0
+	mode := config.errorMode()
+	// batch writes to the columns of table along with its attribute columns
+	batch := NewBatch(tx.Tx, tx.dialect, table.name, columns, config.BatchSize, config.Incremental)
+	defer batch.Close()
+	changes := NewBatch(tx.Tx, tx.dialect, "changes", []string{"generation", "customer_id"}, config.BatchSize, false)
+	defer changes.Close()
+	rejects := NewBatch(tx.Tx, tx.dialect, "rejects", []string{"file", "tablename", "line", "reason", "data"}, config.BatchSize, false)
+	defer rejects.Close()
+	// rows buffered in batch, rejected one by one if the database refuses the batch
+	buffered := []bufferedRow{}
+	// ids and customers of the rows buffered in batch, tracked for incremental imports
+	ids := []interface{}{}
+	customers := []interface{}{}
+	if config.Incremental {
+		var err error
+		if generation, err = nextGeneration(tx); err != nil {
+			return rows, err
+		}
+	}
+	recordChange := func(customer interface{}) error {
+		changes.Add([]interface{}{generation, customer})
+		if changes.Full() {
+			return changes.Flush()
+		}
+		return nil
+	}
+	reject := func(line int, row []string, reason error) error {
+		if err := report.reject(line, row, reason, mode, config.Rejects); err != nil || mode != QuarantineErrors {
+			return err
+		}
+		rejects.Add([]interface{}{report.File, table.name, line, reason.Error(), encodeRow(row)})
+		if rejects.Full() {
+			return rejects.Flush()
+		}
+		return nil
+	}
+	// flush writes the buffered rows within a savepoint, once a constraint refuses them they are written one at a time to reject the refused rows
+	flush := func() error {
+		defer func() { buffered = buffered[:0] }()
+		if mode == FailOnError {
+			return batch.Flush()
+		}
+		err := savepoint(tx, batch.Flush)
+		if err == nil || !tx.dialect.Constraint(err) {
+			return err
+		}
+		for _, row := range buffered {
+			batch.Add(row.values)
+			err := savepoint(tx, batch.Flush)
+			if err == nil {
+				continue
+			}
+			if !tx.dialect.Constraint(err) {
+				return err
+			}
+			report.Accepted--
+			if err := reject(row.line, row.row, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	write := func() error {
+		if !config.Incremental {
+			return flush()
+		}
+		// upsert rows and record the customers they affect so cached cohorts holding those customers are recomputed
+		previous, err := previousCustomers(tx, table, ids)
+		if err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		for i, id := range ids {
+			customer := fmt.Sprint(customers[i])
+			if err := recordChange(customer); err != nil {
+				return err
+			}
+			if previousCustomer, ok := previous[fmt.Sprint(id)]; ok && previousCustomer != customer {
+				if err := recordChange(previousCustomer); err != nil {
+					return err
+				}
+			}
+		}
+		ids, customers = ids[:0], customers[:0]
+		return nil
+	}
+	for {
+		line, number, err := importer.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		// malformed csv and rows not matching the header row are rejected like rows failing validation
+		if parseError, ok := err.(*csv.ParseError); ok {
+			err = parseError.Err
+		} else if _, ok := err.(MismatchError); !ok && err != nil {
+			return rows, err
+		}
+		rows++
+		// rows read by an earlier incremental import are already stored
+		if rows <= skip {
+			continue
+		}
+		if err != nil {
+			if err := reject(number, line, err); err != nil {
+				return rows, err
+			}
+			continue
+		}
+		value, err := transformer(importer.headers, line)
+		if err != nil {
+			if err := reject(number, line, err); err != nil {
+				return rows, err
+			}
+			continue
+		}
+		report.Accepted++
+		values := make([]interface{}, 0, len(columns))
+		for _, column := range table.columns {
+			values = append(values, value[column])
+		}
+		// empty attributes are stored as null so customers without a value form a single segment
+		for _, index := range attributeIndexes {
+			if line[index] == "" {
+				values = append(values, nil)
+			} else {
+				values = append(values, line[index])
+			}
+		}
+		batch.Add(values)
+		buffered = append(buffered, bufferedRow{number, line, values})
+		if config.Incremental {
+			ids = append(ids, value["id"])
+			customers = append(customers, value[table.customerColumn])
+		}
+		if batch.Full() {
+			if err := write(); err != nil {
+				return rows, err
+			}
+		}
+	}
+	if err := write(); err != nil {
+		return rows, err
+	}
+	if err := rejects.Flush(); err != nil {
+		return rows, err
+	}
+	return rows, changes.Flush()
+}
+
+// importFile imports the csv file at path, incremental imports skip rows already imported from the same file
+func importFile(db SQL, path string, table importTable, transformer ImportTransformer, config Config) (ImportReport, error) {
+	report := ImportReport{File: path, Table: table.name}
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	if !config.Incremental {
+		_, err := importCSV(db, file, &report, table, transformer, config, 0)
+		return report, err
+	}
+	offset, err := getImportOffset(db, file, table)
+	if err != nil {
+		return report, err
+	}
+	rows, err := importCSV(db, file, &report, table, transformer, config, offset.rows)
+	if err != nil {
+		return report, err
+	}
+	offset.rows = rows
+	return report, setImportOffset(db, offset)
+}
+
+// importReader imports csv data read from input named name
+func importReader(db SQL, input io.Reader, name string, table importTable, transformer ImportTransformer, config Config) (ImportReport, error) {
+	report := ImportReport{File: name, Table: table.name}
+	_, err := importCSV(db, input, &report, table, transformer, config, 0)
+	return report, err
+}
+
+// ImportCustomers reads the customer csv at path into the customers table
+func ImportCustomers(db SQL, path string, config Config) (ImportReport, error) {
+	return importFile(db, path, customersTable, makeCustomerImportTransformer(config), config)
+}
+
+// ImportCustomersFrom reads customer csv data named name from input into the customers table
+func ImportCustomersFrom(db SQL, input io.Reader, name string, config Config) (ImportReport, error) {
+	return importReader(db, input, name, customersTable, makeCustomerImportTransformer(config), config)
+}
+
+// ImportOrders reads the order csv at path into the orders table
+func ImportOrders(db SQL, path string, config Config) (ImportReport, error) {
+	return importFile(db, path, ordersTable, makeOrderImportTransformer(config), config)
+}
+
+// ImportOrdersFrom reads order csv data named name from input into the orders table
+func ImportOrdersFrom(db SQL, input io.Reader, name string, config Config) (ImportReport, error) {
+	return importReader(db, input, name, ordersTable, makeOrderImportTransformer(config), config)
+}
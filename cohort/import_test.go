@@ -0,0 +1,159 @@
+package cohort
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderImportTransformer(t *testing.T) {
+	transformer := makeOrderImportTransformer(DefaultConfig())
+
+	order, err := transformer(
+		[]string{"id", "order_number", "user_id", "created"},
+		[]string{"26444", "1", "33563", "2015-06-25 01:27:40"},
+	)
+	assert.NoError(t, err, "should transform valid orders")
+	assert.Equal(t, 26444, order["id"], "should parse order id")
+	assert.Equal(t, "2015-06-25T01:27:40", order["created"], "should format created datetime")
+	assert.Equal(t, float64(0), order["amount"], "should default amount when column is missing")
+	assert.Equal(t, "", order["currency"], "should default currency when column is missing")
+
+	order, _ = transformer(
+		[]string{"id", "order_number", "user_id", "created", "currency", "amount"},
+		[]string{"26444", "1", "33563", "2015-06-25 01:27:40", "USD", "19.99"},
+	)
+	assert.Equal(t, 19.99, order["amount"], "should parse amount by header name")
+	assert.Equal(t, "USD", order["currency"], "should read currency by header name")
+
+	_, err = transformer(
+		[]string{"id", "order_number", "user_id", "created"},
+		[]string{"26444", "1", "", "2015-06-25 01:27:40"},
+	)
+	assert.Equal(t, FieldError{"order_user_id", ""}, err, "should reject orders without a customer")
+	_, err = transformer(
+		[]string{"id", "order_number", "user_id", "created"},
+		[]string{"26444", "1", "33563", "yesterday"},
+	)
+	assert.Equal(t, `Invalid ordered_at "yesterday"`, err.Error(), "should reject orders with unparsable datetimes")
+}
+
+func TestColumnMapping(t *testing.T) {
+	path := writeTestCSV(t, "customer_id: account\nsignup_at: signed_up\norder_user_id: account\nordered_at: placed_at\namount: total\n")
+	defer os.Remove(path)
+	mapping, err := LoadColumnMapping(path)
+	assert.NoError(t, err, "should load column mappings")
+	assert.Equal(t, "account", mapping.CustomerID, "should map fields to headers")
+	assert.Equal(t, "id", mapping.OrderID, "should keep default headers of unmapped fields")
+
+	config := DefaultConfig()
+	config.Columns = mapping
+	customer, _ := makeCustomerImportTransformer(config)([]string{"signed_up", "name", "account"}, []string{"2015-06-19 23:49:32", "foobar", "33559"})
+	assert.Equal(t, 33559, customer["id"], "should read customer id by mapped header")
+	assert.Equal(t, "2015-06-19T23:49:32", customer["created"], "should read signup datetime by mapped header")
+	order, _ := makeOrderImportTransformer(config)([]string{"placed_at", "account", "id", "total"}, []string{"2015-06-25 01:27:40", "33563", "26444", "19.99"})
+	assert.Equal(t, 26444, order["id"], "should read order id by mapped header")
+	assert.Equal(t, 33563, order["user_id"], "should read order customer by mapped header")
+	assert.Equal(t, "2015-06-25T01:27:40", order["created"], "should read order datetime by mapped header")
+	assert.Equal(t, 19.99, order["amount"], "should read amount by mapped header")
+	assert.Equal(t, 0, order["order_number"], "should default order number when column is missing")
+
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	_, err = ImportCustomersFrom(db, strings.NewReader("signed_up,account\n2015-06-19 23:49:32,33559\n"), "customers.csv", config)
+	assert.NoError(t, err, "should import customers with mapped headers")
+	_, err = ImportOrdersFrom(db, strings.NewReader(makeTestOrders(1)), "orders.csv", config)
+	assert.IsType(t, MissingHeaderError{}, err, "should fail on importing orders without mapped headers")
+
+	path = writeTestCSV(t, "customer: account\n")
+	defer os.Remove(path)
+	_, err = LoadColumnMapping(path)
+	assert.Error(t, err, "should fail on unknown fields")
+	path = writeTestCSV(t, "ordered_at: \"\"\n")
+	defer os.Remove(path)
+	_, err = LoadColumnMapping(path)
+	assert.Error(t, err, "should fail on unmapped required fields")
+}
+
+// makeTestOrders returns csv data holding count orders
+func makeTestOrders(count int) string {
+	orders := strings.Builder{}
+	orders.WriteString("id,order_number,user_id,created,amount\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&orders, "%d,1,%d,2015-06-25 01:27:40,9.99\n", i+1, i%500)
+	}
+	return orders.String()
+}
+
+func TestImportOrdersFrom(t *testing.T) {
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	config := DefaultConfig()
+	config.BatchSize = 7
+
+	_, err := ImportOrdersFrom(db, strings.NewReader(makeTestOrders(100)), "orders.csv", config)
+	assert.NoError(t, err, "should import orders in batches")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM orders")
+	assert.Equal(t, 100, count, "should write full and partial batches")
+
+	_, err = ImportOrdersFrom(db, strings.NewReader(makeTestOrders(10)), "orders.csv", config)
+	assert.Error(t, err, "should fail on duplicate orders")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM orders")
+	assert.Equal(t, 100, count, "should roll back failed imports")
+
+	config.BatchSize = 1000
+	assert.Equal(t, maxVariables/len(ordersTable.columns), NewBatch(nil, SQLiteDialect{}, "orders", ordersTable.columns, config.BatchSize, false).size, "should cap batches to the parameters a statement accepts")
+}
+
+// BenchmarkImportOrders compares batched imports against inserting every row in its own autocommitted statement
+func BenchmarkImportOrders(b *testing.B) {
+	orders := makeTestOrders(2000)
+	benchmark := func(b *testing.B, importOrders func(db SQL) error) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, cleanup := makeTestSource(b, nil, nil)
+			b.StartTimer()
+			if err := importOrders(db); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			cleanup()
+			b.StartTimer()
+		}
+	}
+	b.Run("row", func(b *testing.B) {
+		benchmark(b, func(db SQL) error {
+			importer, _, err := NewImporter().OpenReader(strings.NewReader(orders))
+			if err != nil {
+				return err
+			}
+			transformer := makeOrderImportTransformer(DefaultConfig())
+			for {
+				value, err := importer.Read(transformer)
+				if err != nil {
+					return nil
+				}
+				values := make([]interface{}, len(ordersTable.columns))
+				for i, column := range ordersTable.columns {
+					values[i] = value[column]
+				}
+				if err := Insert(db, "orders", ordersTable.columns, values); err != nil {
+					return err
+				}
+			}
+		})
+	})
+	for _, size := range []int{1, 100, 1000} {
+		config := DefaultConfig()
+		config.BatchSize = size
+		b.Run(fmt.Sprintf("batch%d", size), func(b *testing.B) {
+			benchmark(b, func(db SQL) error {
+				_, err := ImportOrdersFrom(db, strings.NewReader(orders), "orders.csv", config)
+				return err
+			})
+		})
+	}
+}
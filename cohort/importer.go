@@ -0,0 +1,117 @@
+package cohort
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Importer is used for reading a csv and outputing rows as a map
+type Importer struct {
+	reader  *csv.Reader
+	headers []string
+	// required lists the headers Open fails without
+	required []string
+}
+
+// ImportTransformer defines transform functions used to transform csv rows
+type ImportTransformer func(headers, line []string) (map[string]interface{}, error)
+
+// DefaultTransformer is used when no transform function is specified for Importer.Read
+func DefaultTransformer(headers, line []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for i, value := range line {
+		values[headers[i]] = value
+	}
+	return values, nil
+}
+
+// Open creates a new reader from the specified file path
+func (importer Importer) Open(filepath string) (Importer, bool, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return Importer{}, false, err
+	}
+	return importer.OpenReader(file)
+}
+
+// Require returns an Importer failing to open csv data whose header row lacks any of headers
+func (importer Importer) Require(headers ...string) Importer {
+	importer.required = append(append([]string{}, importer.required...), headers...)
+	return importer
+}
+
+// OpenReader creates a new reader from csv data read from input
+func (importer Importer) OpenReader(input io.Reader) (Importer, bool, error) {
+	reader := csv.NewReader(input)
+	// rows of any length are read so mismatched rows are rejected without ending the import
+	reader.FieldsPerRecord = -1
+	if headers, err := reader.Read(); err == nil {
+		importer.headers = headers
+		importer.reader = reader
+	} else {
+		return Importer{}, false, err
+	}
+	for _, header := range importer.required {
+		if headerIndex(importer.headers, header) == -1 {
+			return Importer{}, false, MissingHeaderError{header, importer.headers}
+		}
+	}
+	return importer, true, nil
+}
+
+// MissingHeaderError implements error interface and identifies required headers missing from the csv header row
+type MissingHeaderError struct {
+	header  string
+	headers []string
+}
+
+// Error returns an error message identifying the missing header along with the headers found
+func (err MissingHeaderError) Error() string {
+	return fmt.Sprintf("Missing required header %q in csv with headers %v", err.header, err.headers)
+}
+
+// MismatchError implements error interface and identifies rows that dont match csv header row length
+type MismatchError struct {
+	headerLength int
+	lineLength   int
+}
+
+// Error returns an error message identifying mismatched lengths in csv rows
+func (err MismatchError) Error() string {
+	return fmt.Sprintf("Mismatched values in csv wanted %d got %d", err.headerLength, err.lineLength)
+}
+
+// ReadLine gets the next line of the csv along with the line number it starts on, malformed and mismatched lines return the line along with the error
+func (importer Importer) ReadLine() ([]string, int, error) {
+	line, err := importer.reader.Read()
+	if err != nil {
+		if parseError, ok := err.(*csv.ParseError); ok {
+			return line, parseError.StartLine, parseError
+		}
+		return nil, 0, err
+	}
+	number, _ := importer.reader.FieldPos(0)
+	if len(line) != len(importer.headers) {
+		return line, number, MismatchError{len(importer.headers), len(line)}
+	}
+	return line, number, nil
+}
+
+// Read gets the next line of the csv and performs transformation on the data
+func (importer Importer) Read(transformer ImportTransformer) (map[string]interface{}, error) {
+	if transformer == nil {
+		transformer = DefaultTransformer
+	}
+	line, _, err := importer.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	return transformer(importer.headers, line)
+}
+
+// NewImporter creates a new instance of Importer
+func NewImporter() Importer {
+	return Importer{}
+}
@@ -1,4 +1,4 @@
-package main
+package cohort
 
 import (
 	"encoding/csv"
@@ -37,7 +37,11 @@ func TestImporter(t *testing.T) {
 
 	assert.False(t, ok, "should fail on opening non csv file")
 
-	reader, ok, _ := NewImporter().Open(validFile.Name())
+	_, ok, err := NewImporter().Require("id", "created").Open(validFile.Name())
+	assert.False(t, ok, "should fail on opening csv file missing required headers")
+	assert.Equal(t, `Missing required header "created" in csv with headers [id name]`, err.Error(), "should identify the missing header")
+
+	reader, ok, _ := NewImporter().Require("name").Open(validFile.Name())
 
 	assert.True(t, ok, "should successfully open csv file")
 	assert.ElementsMatch(t, []string{"id", "name"}, reader.headers, "should match headers defined in csv")
@@ -48,19 +52,19 @@ func TestImporter(t *testing.T) {
 		row,
 	), "should format row using default transformer")
 
-	row, _ = reader.Read(func(headers, line []string) map[string]interface{} {
+	row, _ = reader.Read(func(headers, line []string) (map[string]interface{}, error) {
 		values := make(map[string]interface{})
 		for i, value := range line {
 			values[headers[i]] = strings.ToUpper(value)
 		}
-		return values
+		return values, nil
 	})
 	assert.True(t, assert.ObjectsAreEqualValues(
 		map[string]interface{}{"id": "2", "name": "FOOBAR"},
 		row,
 	), "should format row by uppercasing string values")
 
-	_, err := reader.Read(nil)
+	_, err = reader.Read(nil)
 	assert.Error(t, err, "should return an error for mismatched row length")
 	assert.Equal(t, "Mismatched values in csv wanted 2 got 3", err.Error(), "should conform to mismatch error message")
 }
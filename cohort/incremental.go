@@ -0,0 +1,303 @@
+package cohort
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// importSchema records how much of every file was stored by incremental imports along with a checksum of the imported content
+var importSchema = map[string]string{
+	"file":      "varchar(512) not null primary key",
+	"tablename": "varchar(64) not null",
+	"rowcount":  "int not null",
+	"size":      "bigint not null",
+	"checksum":  "varchar(64) not null default ''",
+}
+
+// changeSchema records the customers affected by every incremental import generation
+var changeSchema = map[string]string{
+	"generation":  "int not null",
+	"customer_id": "int not null",
+}
+
+// cohortCacheSchema stores computed cohorts along with the generation they were computed at
+var cohortCacheSchema = map[string]string{
+	"id":         "varchar(255) not null primary key",
+	"generation": "int not null",
+	"data":       "text not null",
+}
+
+func makeIncrementalTables(db SQL) error {
+	if err := CreateTable(db, "imports", importSchema); err != nil {
+		return fmt.Errorf("Failed to create imports table with error %s", err.Error())
+	}
+
+	// imports recorded before checksums were stored have an empty checksum so their files are imported again from the start
+	if err := addMissingColumn(db, "imports", "checksum", importSchema["checksum"]); err != nil {
+		return fmt.Errorf("Failed to add checksum to imports table with error %s", err.Error())
+	}
+
+	if err := CreateTable(db, "changes", changeSchema); err != nil {
+		return fmt.Errorf("Failed to create changes table with error %s", err.Error())
+	}
+
+	if err := CreateTable(db, "cohort_cache", cohortCacheSchema); err != nil {
+		return fmt.Errorf("Failed to create cohort_cache table with error %s", err.Error())
+	}
+
+	return nil
+}
+
+// addMissingColumn adds column to a table created without it
+func addMissingColumn(db SQL, table, column, columnType string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, existing := range columns {
+		if strings.EqualFold(existing, column) {
+			return nil
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, dialectOf(db).ColumnType(columnType)))
+	return err
+}
+
+// queryInt runs a query returning a single integer, no rows or a null value return 0
+func queryInt(db Executor, statement string, args ...interface{}) (int, error) {
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var value *int
+	if rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			return 0, err
+		}
+	}
+	if value == nil {
+		return 0, rows.Err()
+	}
+	return *value, rows.Err()
+}
+
+// currentGeneration returns the generation of the latest incremental import that changed any rows
+func currentGeneration(db Executor) (int, error) {
+	return queryInt(db, "SELECT MAX(generation) FROM changes")
+}
+
+func nextGeneration(db Executor) (int, error) {
+	generation, err := currentGeneration(db)
+	return generation + 1, err
+}
+
+// previousCustomers maps the ids of stored rows to the customer they belong to ahead of being replaced
+func previousCustomers(db Executor, table importTable, ids []interface{}) (map[string]string, error) {
+	previous := map[string]string{}
+	if len(ids) == 0 {
+		return previous, nil
+	}
+	builder := dialectOf(db).Flavor().NewSelectBuilder()
+	builder.Select("id", table.customerColumn).From(table.name).Where(builder.In("id", ids...))
+	statement, args := builder.Build()
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, customer string
+		if err := rows.Scan(&id, &customer); err != nil {
+			return nil, err
+		}
+		previous[id] = customer
+	}
+	return previous, rows.Err()
+}
+
+// importOffset is the number of rows of a file stored by an earlier incremental import along with the size and checksum of the content read
+type importOffset struct {
+	file     string
+	table    string
+	rows     int
+	size     int64
+	checksum string
+}
+
+// getImportOffset finds the rows of file already imported, files whose previously imported content changed since are imported from the start
+func getImportOffset(db SQL, file *os.File, table importTable) (importOffset, error) {
+	offset := importOffset{table: table.name}
+	path, err := filepath.Abs(file.Name())
+	if err != nil {
+		return offset, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	offset.file = path
+	offset.size = info.Size()
+
+	builder := dialectOf(db).Flavor().NewSelectBuilder()
+	builder.Select("rowcount", "size", "checksum").From("imports").Where(builder.Equal("file", path), builder.Equal("tablename", table.name))
+	statement, args := builder.Build()
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return offset, err
+	}
+	var (
+		found    bool
+		imported int
+		size     int64
+		checksum string
+	)
+	if found = rows.Next(); found {
+		if err := rows.Scan(&imported, &size, &checksum); err != nil {
+			rows.Close()
+			return offset, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return offset, err
+	}
+	// rows are only skipped when the content they were read from is unchanged, appending to a file keeps its imported prefix
+	hash := sha256.New()
+	read := int64(0)
+	if found && size <= offset.size {
+		if _, err := io.CopyN(hash, file, size); err != nil {
+			return offset, err
+		}
+		read = size
+		if checksum != "" && hex.EncodeToString(hash.Sum(nil)) == checksum {
+			offset.rows = imported
+		}
+	}
+	if _, err := io.CopyN(hash, file, offset.size-read); err != nil {
+		return offset, err
+	}
+	offset.checksum = hex.EncodeToString(hash.Sum(nil))
+	_, err = file.Seek(0, io.SeekStart)
+	return offset, err
+}
+
+func setImportOffset(db SQL, offset importOffset) error {
+	return Upsert(db, "imports", []string{"file", "tablename", "rowcount", "size", "checksum"}, []interface{}{offset.file, offset.table, offset.rows, offset.size, offset.checksum})
+}
+
+// cohortCacheID identifies a cohort window computed with the settings of config that change its aggregated orders
+func cohortCacheID(start time.Time, config Config) string {
+	settings := []string{
+		string(config.CohortPeriod),
+		string(config.cohortBy()),
+		fmt.Sprint(config.bucketing()),
+		string(config.RetentionMode),
+		config.Location.String(),
+		start.Format("2006-01-02T15:04:05"),
+	}
+	if config.segment != nil {
+		settings = append(settings, config.segment.id())
+	}
+	// filters are hashed so long expressions fit the id column
+	if !config.CustomerFilter.IsZero() || !config.OrderFilter.IsZero() {
+		settings = append(settings, fmt.Sprintf("%x", sha1.Sum([]byte(config.CustomerFilter.String()+"\x00"+config.OrderFilter.String()))))
+	}
+	return strings.Join(settings, "|")
+}
+
+// loadCohort returns the cached cohort starting at start if no import changed its customers since it was cached
+func loadCohort(db SQL, start, end time.Time, config Config) (Cohort, bool, error) {
+	cohort := Cohort{}
+	builder := dialectOf(db).Flavor().NewSelectBuilder()
+	builder.Select("generation", "data").From("cohort_cache").Where(builder.Equal("id", cohortCacheID(start, config)))
+	statement, args := builder.Build()
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return cohort, false, err
+	}
+	var generation int
+	var data string
+	found := rows.Next()
+	if found {
+		if err := rows.Scan(&generation, &data); err != nil {
+			rows.Close()
+			return cohort, false, err
+		}
+	}
+	rows.Close()
+	if !found {
+		return cohort, false, nil
+	}
+	if err := json.Unmarshal([]byte(data), &cohort); err != nil {
+		return cohort, false, err
+	}
+	// a cohort is stale once a later import touched a customer now joining within its window
+	schema := config.Schema
+	window := func(cond *sqlbuilder.Cond) string {
+		return cond.And(cond.GreaterThan("changes.generation", generation), cond.GreaterEqualThan("members.created", formatStored(start)), cond.LessThan("members.created", formatStored(end)))
+	}
+	statement, args = sqlbuilder.Build(fmt.Sprintf("WITH members AS (SELECT %s AS id, %s AS created FROM %s) SELECT COUNT(*) FROM changes JOIN members ON members.id = changes.customer_id WHERE $?", schema.CustomerID, schema.CustomerCreated, schema.CustomersTable), buildCondition(window)).BuildWithFlavor(dialectOf(db).Flavor())
+	count, err := queryInt(db, statement, args...)
+	if err != nil || count != 0 {
+		return cohort, false, err
+	}
+	// or touched a customer it holds, changed customers are matched in go rather than listing the ids of the cohort in the statement
+	builder = dialectOf(db).Flavor().NewSelectBuilder()
+	builder.Select("customer_id").Distinct().From("changes").Where(builder.GreaterThan("generation", generation))
+	statement, args = builder.Build()
+	rows, err = db.Query(statement, args...)
+	if err != nil {
+		return cohort, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var customer string
+		if err := rows.Scan(&customer); err != nil {
+			return cohort, false, err
+		}
+		if _, ok := cohort.Customers[customer]; ok {
+			return cohort, false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return cohort, false, err
+	}
+	// restore the configured location lost when encoding the window
+	cohort.Start = cohort.Start.In(config.Location)
+	cohort.End = cohort.End.In(config.Location)
+	return cohort, true, nil
+}
+
+// storeCohort caches cohort as computed at the current import generation and prunes the changes no cached cohort needs
+func storeCohort(db SQL, cohort Cohort, config Config) error {
+	generation, err := currentGeneration(db)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cohort)
+	if err != nil {
+		return err
+	}
+	if err := Upsert(db, "cohort_cache", []string{"id", "generation", "data"}, []interface{}{cohortCacheID(cohort.Start, config), generation, string(data)}); err != nil {
+		return err
+	}
+	// changes up to the oldest cached cohort invalidate nothing, the latest generation is kept for the next import
+	_, err = db.Exec("DELETE FROM changes WHERE generation < (SELECT MIN(generation) FROM cohort_cache)")
+	return err
+}
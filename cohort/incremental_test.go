@@ -0,0 +1,155 @@
+package cohort
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCSV writes content to a temporary csv file and returns its path
+func writeTestCSV(t *testing.T, content string) string {
+	tmp, err := ioutil.TempFile("", "test-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return tmp.Name()
+}
+
+func TestIncrementalImport(t *testing.T) {
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	config := DefaultConfig()
+	config.Incremental = true
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	customers := writeTestCSV(t, "id,created\n33559,2015-06-19 23:49:32\n33563,2015-06-20 00:09:03\n")
+	defer os.Remove(customers)
+	orders := writeTestCSV(t, "id,order_number,user_id,created\n26444,1,33563,2015-06-25 01:27:40\n")
+	defer os.Remove(orders)
+	_, err := ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should import customers incrementally")
+	_, err = ImportOrders(db, orders, config)
+	assert.NoError(t, err, "should import orders incrementally")
+
+	result, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts without error")
+	assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)"}, result.Rows[0], "should compute cohorts from imported rows")
+	generation, _ := currentGeneration(db)
+	assert.Equal(t, 2, generation, "should record a generation per import")
+
+	// reimporting unchanged files stores nothing
+	_, err = ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should reimport customers without error")
+	generation, _ = currentGeneration(db)
+	assert.Equal(t, 2, generation, "should skip rows already imported")
+
+	// appended rows are imported, files whose imported rows changed are imported again and replaced rows keep a single copy
+	ioutil.WriteFile(customers, []byte("id,created\n33559,2015-06-19 23:49:32\n33563,2015-06-20 00:09:03\n33600,2015-07-10 10:00:00\n"), 0644)
+	ioutil.WriteFile(orders, []byte("id,order_number,user_id,created\n26444,1,33559,2015-06-25 01:27:40\n26445,1,33600,2015-07-11 01:00:00\n"), 0644)
+	_, err = ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should import appended customers")
+	_, err = ImportOrders(db, orders, config)
+	assert.NoError(t, err, "should import rewritten orders")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM orders")
+	assert.Equal(t, 2, count, "should upsert orders by primary key")
+	user, _ := queryInt(db, "SELECT user_id FROM orders WHERE id = 26444")
+	assert.Equal(t, 33559, user, "should upsert rewritten orders")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM customers")
+	assert.Equal(t, 3, count, "should append new customers")
+
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts without error")
+	assert.Equal(t, []string{"07/10/2015-07/16/2015", "1 customers", "100.00% orderers (1)"}, result.Rows[0], "should compute cohorts of new customers")
+	assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)"}, result.Rows[6], "should recompute cohorts of changed customers")
+	assert.Equal(t, "2015-06-19T23:49:32Z", result.Cohorts[0].Customers["33559"].Format("2006-01-02T15:04:05Z"), "should include customers of recomputed cohorts")
+
+	// rows edited in place are read again even when the file keeps its size
+	ioutil.WriteFile(orders, []byte("id,order_number,user_id,created\n26444,1,33563,2015-06-25 01:27:40\n26445,1,33600,2015-07-11 01:00:00\n"), 0644)
+	_, err = ImportOrders(db, orders, config)
+	assert.NoError(t, err, "should import orders edited in place")
+	user, _ = queryInt(db, "SELECT user_id FROM orders WHERE id = 26444")
+	assert.Equal(t, 33563, user, "should upsert orders edited in place")
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts without error")
+	assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)"}, result.Rows[6], "should recompute cohorts of customers whose orders were edited")
+	assert.Equal(t, map[int]Orders{0: {map[string]bool{"33563": true}, 1, 1, 0}}, result.Cohorts[0].Orders, "should move edited orders to their new customer")
+}
+
+func TestCohortCache(t *testing.T) {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{33559, "2015-06-19T23:49:32"},
+		{33600, "2015-06-27T10:00:00"},
+	}, [][]interface{}{
+		{26444, 1, 33559, "2015-06-25T01:27:40", 10.0},
+	})
+	defer cleanup()
+	config := DefaultConfig()
+	config.Incremental = true
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	result, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cohorts without error")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM cohort_cache")
+	assert.Equal(t, 2, count, "should cache every computed cohort")
+
+	// rows changed without recording a generation are invisible to cached cohorts
+	Insert(db, "orders", []string{"id", "order_number", "user_id", "created", "amount"}, []interface{}{26445, 1, 33600, "2015-06-28T01:27:40", 10.0})
+	cached, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute cached cohorts without error")
+	assert.Equal(t, result.Rows, cached.Rows, "should reuse cached cohorts")
+	assert.True(t, cached.Cohorts[0].Start.Equal(result.Cohorts[0].Start), "should restore cached cohort windows")
+
+	// recording the change only invalidates the cohort holding the customer
+	Insert(db, "changes", []string{"generation", "customer_id"}, []interface{}{1, 33600})
+	updated, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute updated cohorts without error")
+	assert.Equal(t, result.Rows[2:], updated.Rows[2:], "should keep unchanged cohorts")
+	assert.Equal(t, []string{"06/26/2015-07/02/2015", "1 customers", "100.00% orderers (1)"}, updated.Rows[0], "should recompute changed cohorts")
+
+	// a customer joining exactly at the start of a window only invalidates its cohort
+	Insert(db, "customers", []string{"id", "created"}, []interface{}{33700, "2015-06-26T00:00:00"})
+	Insert(db, "changes", []string{"generation", "customer_id"}, []interface{}{2, 33700})
+	joined, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute joined cohorts without error")
+	assert.Equal(t, result.Rows[2:], joined.Rows[2:], "should keep the cohort ending as the customer joins")
+	assert.Equal(t, []string{"06/26/2015-07/02/2015", "2 customers", "50.00% orderers (1)"}, joined.Rows[0], "should recompute the cohort the customer joins")
+
+	config.BucketPeriod = Day
+	_, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute daily buckets without error")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM cohort_cache")
+	assert.Equal(t, 4, count, "should cache cohorts computed with other settings separately")
+
+	// once every cached cohort is newer than a generation its changes are pruned
+	Insert(db, "changes", []string{"generation", "customer_id"}, []interface{}{3, 33559})
+	config.BucketPeriod = DefaultConfig().BucketPeriod
+	_, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute changed cohorts without error")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM changes WHERE generation < 2")
+	assert.Equal(t, 0, count, "should prune changes older than every cached cohort")
+	generation, _ := currentGeneration(db)
+	assert.Equal(t, 3, generation, "should keep the latest generation")
+}
+
+func TestImportsChecksumColumn(t *testing.T) {
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	// imports tables created before checksums were recorded gain the column
+	_, err := db.Exec("DROP TABLE imports")
+	assert.NoError(t, err, "should drop imports table")
+	assert.NoError(t, CreateTable(db, "imports", map[string]string{"file": "varchar(512) not null primary key", "tablename": "varchar(64) not null", "rowcount": "int not null", "size": "bigint not null"}), "should create imports table without checksums")
+	assert.NoError(t, Insert(db, "imports", []string{"file", "tablename", "rowcount", "size"}, []interface{}{"orders.csv", "orders", 1, 10}), "should record imports without checksums")
+	assert.NoError(t, makeIncrementalTables(db), "should add missing checksums")
+	assert.NoError(t, makeIncrementalTables(db), "should keep existing checksums")
+	count, err := queryInt(db, "SELECT COUNT(*) FROM imports WHERE checksum = ''")
+	assert.NoError(t, err, "should query checksums")
+	assert.Equal(t, 1, count, "should leave earlier imports without a checksum")
+}
@@ -0,0 +1,88 @@
+package cohort
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONExporter writes cohorts as structured json with raw counts and rates as numbers
+type JSONExporter struct{}
+
+type jsonResult struct {
+	Buckets       []string     `json:"buckets"`
+	ObservedUntil string       `json:"observed_until"`
+	Cohorts       []jsonCohort `json:"cohorts"`
+	// SegmentBy and Segments are only written for segmented results
+	SegmentBy string        `json:"segment_by,omitempty"`
+	Segments  []jsonSegment `json:"segments,omitempty"`
+}
+
+type jsonSegment struct {
+	Value   string       `json:"value"`
+	Cohorts []jsonCohort `json:"cohorts"`
+}
+
+type jsonCohort struct {
+	Start     string       `json:"start"`
+	End       string       `json:"end"`
+	Customers int          `json:"customers"`
+	Buckets   []jsonBucket `json:"buckets"`
+}
+
+type jsonBucket struct {
+	Bucket   string       `json:"bucket"`
+	Censored bool         `json:"censored"`
+	Metrics  []jsonMetric `json:"metrics"`
+}
+
+// jsonMetric holds null counts and rates for censored buckets
+type jsonMetric struct {
+	Metric string   `json:"metric"`
+	Count  *float64 `json:"count"`
+	Rate   *float64 `json:"rate"`
+}
+
+// Export writes result as indented json
+func (exporter JSONExporter) Export(output io.Writer, result Result) error {
+	structured := jsonResult{result.Buckets, result.ObservedUntil.Format(time.RFC3339), makeJSONCohorts(result), "", nil}
+	// segmented results write the cohorts of every segment in place of their own
+	if result.segmented() {
+		structured.SegmentBy = result.Config.SegmentBy
+		structured.Segments = make([]jsonSegment, 0, len(result.Segments))
+		for _, segment := range result.Segments {
+			structured.Segments = append(structured.Segments, jsonSegment{segment.Value, makeJSONCohorts(segment.Result)})
+		}
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(structured)
+}
+
+// makeJSONCohorts structures the cohorts of result in chronological order with the count and rate of each metric per bucket
+func makeJSONCohorts(result Result) []jsonCohort {
+	cohorts := make([]jsonCohort, 0, len(result.Cohorts))
+	for _, cohort := range result.Cohorts {
+		structuredCohort := jsonCohort{
+			cohort.Start.Format("2006-01-02"),
+			cohort.End.AddDate(0, 0, -1).Format("2006-01-02"),
+			len(cohort.Customers),
+			make([]jsonBucket, cohort.MaxBucket+1),
+		}
+		for bucket := range structuredCohort.Buckets {
+			structuredCohort.Buckets[bucket].Bucket = result.Buckets[bucket]
+			structuredCohort.Buckets[bucket].Censored = cohort.Censored(bucket)
+			structuredCohort.Buckets[bucket].Metrics = make([]jsonMetric, 0, len(cohort.Metrics))
+			for _, values := range cohort.Metrics {
+				metric := jsonMetric{Metric: values.Metric.Name()}
+				if !cohort.Censored(bucket) {
+					value := values.Values[bucket]
+					metric.Count, metric.Rate = &value.Count, &value.Rate
+				}
+				structuredCohort.Buckets[bucket].Metrics = append(structuredCohort.Buckets[bucket].Metrics, metric)
+			}
+		}
+		cohorts = append(cohorts, structuredCohort)
+	}
+	return cohorts
+}
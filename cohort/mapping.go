@@ -0,0 +1,74 @@
+package cohort
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ColumnMapping binds the fields read from imported csv files to the names of their header columns
+type ColumnMapping struct {
+	CustomerID string `yaml:"customer_id"`
+	SignupAt   string `yaml:"signup_at"`
+	OrderID    string `yaml:"order_id"`
+	// OrderNumber, Amount and Currency are optional, orders without them store zero values
+	OrderNumber string `yaml:"order_number"`
+	OrderUserID string `yaml:"order_user_id"`
+	OrderedAt   string `yaml:"ordered_at"`
+	Amount      string `yaml:"amount"`
+	Currency    string `yaml:"currency"`
+}
+
+// DefaultColumnMapping returns the ColumnMapping of the headers in the bundled customers and orders csv files
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		CustomerID:  "id",
+		SignupAt:    "created",
+		OrderID:     "id",
+		OrderNumber: "order_number",
+		OrderUserID: "user_id",
+		OrderedAt:   "created",
+		Amount:      "amount",
+		Currency:    "currency",
+	}
+}
+
+// LoadColumnMapping reads a yaml file of fields and header names, fields missing from the file keep their default header
+func LoadColumnMapping(path string) (ColumnMapping, error) {
+	mapping := DefaultColumnMapping()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mapping, err
+	}
+	if err := yaml.UnmarshalStrict(data, &mapping); err != nil {
+		return mapping, fmt.Errorf("Failed to read column mapping %s with error %s", path, err.Error())
+	}
+	return mapping, mapping.Validate()
+}
+
+// Validate checks every required field is bound to a header
+func (mapping ColumnMapping) Validate() error {
+	for _, field := range []struct{ name, header string }{
+		{"customer_id", mapping.CustomerID},
+		{"signup_at", mapping.SignupAt},
+		{"order_id", mapping.OrderID},
+		{"order_user_id", mapping.OrderUserID},
+		{"ordered_at", mapping.OrderedAt},
+	} {
+		if field.header == "" {
+			return fmt.Errorf("Missing header for required field %s in column mapping", field.name)
+		}
+	}
+	return nil
+}
+
+// customerHeaders are the headers every imported customer csv must contain
+func (mapping ColumnMapping) customerHeaders() []string {
+	return []string{mapping.CustomerID, mapping.SignupAt}
+}
+
+// orderHeaders are the headers every imported order csv must contain
+func (mapping ColumnMapping) orderHeaders() []string {
+	return []string{mapping.OrderID, mapping.OrderUserID, mapping.OrderedAt}
+}
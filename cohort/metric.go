@@ -0,0 +1,310 @@
+package cohort
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Value is the outcome of a metric for a single cohort bucket
+type Value struct {
+	// Count is the raw quantity measured such as customers, orders or revenue
+	Count float64
+	// Rate is the count normalized by the metric such as a share of cohort customers
+	Rate float64
+}
+
+// MetricValues holds the value of a metric for every bucket of a cohort
+type MetricValues struct {
+	Metric Metric
+	Values []Value
+}
+
+// Metric accumulates the orders of consecutive cohort buckets and produces a value for each of them
+type Metric interface {
+	// Name identifies the metric when selecting metrics
+	Name() string
+	// Accumulate adds the orders placed in the next bucket of cohort
+	Accumulate(cohort Cohort, orders Orders)
+	// Finalize returns the value of the most recently accumulated bucket
+	Finalize(cohort Cohort) Value
+	// Format renders a value as a csv cell
+	Format(value Value) string
+}
+
+// MetricFactory creates a Metric with empty state, a new Metric is created for every cohort
+type MetricFactory func() Metric
+
+var metricRegistry = map[string]MetricFactory{}
+
+// RegisterMetric makes a metric selectable by name, registering an existing name replaces it
+func RegisterMetric(name string, factory MetricFactory) {
+	metricRegistry[name] = factory
+}
+
+// NewMetric creates the registered metric with the given name
+func NewMetric(name string) (Metric, error) {
+	factory, ok := metricRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown metric %q expected one of %v", name, MetricNames())
+	}
+	return factory(), nil
+}
+
+// ParseMetrics returns the names of a comma separated list of metrics with surrounding spaces and empty names left out, or an error if a name is not registered
+func ParseMetrics(value string) ([]string, error) {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, err := NewMetric(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No metrics in %q expected one of %v", value, MetricNames())
+	}
+	return names, nil
+}
+
+// MetricNames returns the sorted names of all registered metrics
+func MetricNames() []string {
+	names := make([]string, 0, len(metricRegistry))
+	for name := range metricRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterMetric("orderers", func() Metric { return &orderersMetric{} })
+	RegisterMetric("first_time", func() Metric { return &firstTimeMetric{} })
+	RegisterMetric("order_count", func() Metric { return &orderCountMetric{} })
+	RegisterMetric("orders_per_customer", func() Metric { return &ordersPerCustomerMetric{} })
+	RegisterMetric("repeat_rate", func() Metric { return &repeatRateMetric{} })
+	RegisterMetric("cumulative_retention", func() Metric { return &cumulativeRetentionMetric{} })
+	RegisterMetric("ltv", func() Metric { return &lifetimeValueMetric{} })
+	RegisterMetric("aov", func() Metric { return &averageOrderValueMetric{} })
+	RegisterMetric("nrr", func() Metric { return &netRevenueRetentionMetric{} })
+}
+
+// revenueMetrics names the registered metrics summing order amounts
+var revenueMetrics = map[string]bool{"ltv": true, "aov": true, "nrr": true}
+
+// share returns count as a fraction of total or zero when total is zero
+func share(count, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return count / total
+}
+
+// orderersMetric counts the unique customers ordering in a bucket
+type orderersMetric struct {
+	count int
+}
+
+func (metric *orderersMetric) Name() string {
+	return "orderers"
+}
+
+func (metric *orderersMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.count = len(orders.UniqueOrders)
+}
+
+func (metric *orderersMetric) Finalize(cohort Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(len(cohort.Customers)))}
+}
+
+func (metric *orderersMetric) Format(value Value) string {
+	if value.Count == 0 {
+		return "0% orderers (0)"
+	}
+	return fmt.Sprintf("%.2f%% orderers (%d)", value.Rate*100, int(value.Count))
+}
+
+// firstTimeMetric counts the customers placing their first order in a bucket
+type firstTimeMetric struct {
+	count int
+}
+
+func (metric *firstTimeMetric) Name() string {
+	return "first_time"
+}
+
+func (metric *firstTimeMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.count = orders.FirstTimeOrders
+}
+
+func (metric *firstTimeMetric) Finalize(cohort Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(len(cohort.Customers)))}
+}
+
+func (metric *firstTimeMetric) Format(value Value) string {
+	if value.Count == 0 {
+		return "0% 1st time (0)"
+	}
+	return fmt.Sprintf("%.2f%% 1st time (%d)", value.Rate*100, int(value.Count))
+}
+
+// orderCountMetric counts the orders placed in a bucket, its rate is orders per cohort customer
+type orderCountMetric struct {
+	count int
+}
+
+func (metric *orderCountMetric) Name() string {
+	return "order_count"
+}
+
+func (metric *orderCountMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.count = orders.Count
+}
+
+func (metric *orderCountMetric) Finalize(cohort Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(len(cohort.Customers)))}
+}
+
+func (metric *orderCountMetric) Format(value Value) string {
+	return fmt.Sprintf("%d orders", int(value.Count))
+}
+
+// ordersPerCustomerMetric averages the orders placed in a bucket by each customer ordering in it
+type ordersPerCustomerMetric struct {
+	count     int
+	customers int
+}
+
+func (metric *ordersPerCustomerMetric) Name() string {
+	return "orders_per_customer"
+}
+
+func (metric *ordersPerCustomerMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.count = orders.Count
+	metric.customers = len(orders.UniqueOrders)
+}
+
+func (metric *ordersPerCustomerMetric) Finalize(_ Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(metric.customers))}
+}
+
+func (metric *ordersPerCustomerMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f orders per customer (%d)", value.Rate, int(value.Count))
+}
+
+// repeatRateMetric measures the share of customers ordering in a bucket who had already ordered before it
+type repeatRateMetric struct {
+	count     int
+	customers int
+}
+
+func (metric *repeatRateMetric) Name() string {
+	return "repeat_rate"
+}
+
+func (metric *repeatRateMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.customers = len(orders.UniqueOrders)
+	metric.count = metric.customers - orders.FirstTimeOrders
+}
+
+func (metric *repeatRateMetric) Finalize(_ Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(metric.customers))}
+}
+
+func (metric *repeatRateMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f%% repeat (%d)", value.Rate*100, int(value.Count))
+}
+
+// cumulativeRetentionMetric measures the share of cohort customers who have ordered at least once by the end of a bucket
+type cumulativeRetentionMetric struct {
+	count int
+}
+
+func (metric *cumulativeRetentionMetric) Name() string {
+	return "cumulative_retention"
+}
+
+func (metric *cumulativeRetentionMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.count += orders.FirstTimeOrders
+}
+
+func (metric *cumulativeRetentionMetric) Finalize(cohort Cohort) Value {
+	return Value{float64(metric.count), share(float64(metric.count), float64(len(cohort.Customers)))}
+}
+
+func (metric *cumulativeRetentionMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f%% retained (%d)", value.Rate*100, int(value.Count))
+}
+
+// lifetimeValueMetric measures cumulative revenue per cohort customer
+type lifetimeValueMetric struct {
+	revenue float64
+}
+
+func (metric *lifetimeValueMetric) Name() string {
+	return "ltv"
+}
+
+func (metric *lifetimeValueMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.revenue += orders.Revenue
+}
+
+func (metric *lifetimeValueMetric) Finalize(cohort Cohort) Value {
+	return Value{metric.revenue, share(metric.revenue, float64(len(cohort.Customers)))}
+}
+
+func (metric *lifetimeValueMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f ltv (%.2f)", value.Rate, value.Count)
+}
+
+// averageOrderValueMetric measures revenue per order placed in a bucket
+type averageOrderValueMetric struct {
+	revenue float64
+	count   int
+}
+
+func (metric *averageOrderValueMetric) Name() string {
+	return "aov"
+}
+
+func (metric *averageOrderValueMetric) Accumulate(_ Cohort, orders Orders) {
+	metric.revenue = orders.Revenue
+	metric.count = orders.Count
+}
+
+func (metric *averageOrderValueMetric) Finalize(_ Cohort) Value {
+	return Value{float64(metric.count), share(metric.revenue, float64(metric.count))}
+}
+
+func (metric *averageOrderValueMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f aov (%d)", value.Rate, int(value.Count))
+}
+
+// netRevenueRetentionMetric measures revenue in a bucket relative to revenue in the first bucket
+type netRevenueRetentionMetric struct {
+	buckets        int
+	revenue        float64
+	initialRevenue float64
+}
+
+func (metric *netRevenueRetentionMetric) Name() string {
+	return "nrr"
+}
+
+func (metric *netRevenueRetentionMetric) Accumulate(_ Cohort, orders Orders) {
+	if metric.buckets == 0 {
+		metric.initialRevenue = orders.Revenue
+	}
+	metric.revenue = orders.Revenue
+	metric.buckets++
+}
+
+func (metric *netRevenueRetentionMetric) Finalize(_ Cohort) Value {
+	return Value{metric.revenue, share(metric.revenue, metric.initialRevenue)}
+}
+
+func (metric *netRevenueRetentionMetric) Format(value Value) string {
+	return fmt.Sprintf("%.2f%% nrr (%.2f)", value.Rate*100, value.Count)
+}
@@ -0,0 +1,91 @@
+package cohort
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// accumulateMetric runs the named metric over buckets of orders and returns the formatted value of every bucket
+func accumulateMetric(t *testing.T, name string, cohort Cohort, buckets []Orders) []string {
+	metric, err := NewMetric(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, name, metric.Name(), "should create metric matching name")
+	cells := []string{}
+	for _, orders := range buckets {
+		metric.Accumulate(cohort, orders)
+		cells = append(cells, metric.Format(metric.Finalize(cohort)))
+	}
+	return cells
+}
+
+func TestMetrics(t *testing.T) {
+	_, err := NewMetric("churn")
+	assert.Error(t, err, "should fail on unknown metric")
+	assert.Contains(t, MetricNames(), "repeat_rate", "should list registered metrics")
+
+	cohort := Cohort{Customers: map[string]time.Time{"1": {}, "2": {}, "3": {}, "4": {}}}
+	buckets := []Orders{
+		{map[string]bool{"1": true, "2": true}, 2, 3, 30},
+		{},
+		{map[string]bool{"1": true, "3": true}, 1, 4, 60},
+	}
+
+	assert.Equal(t, []string{"50.00% orderers (2)", "0% orderers (0)", "50.00% orderers (2)"}, accumulateMetric(t, "orderers", cohort, buckets), "should report unique orderers per bucket")
+	assert.Equal(t, []string{"3 orders", "0 orders", "4 orders"}, accumulateMetric(t, "order_count", cohort, buckets), "should report orders per bucket")
+	assert.Equal(t, []string{"1.50 orders per customer (3)", "0.00 orders per customer (0)", "2.00 orders per customer (4)"}, accumulateMetric(t, "orders_per_customer", cohort, buckets), "should report orders per ordering customer")
+	assert.Equal(t, []string{"0.00% repeat (0)", "0.00% repeat (0)", "50.00% repeat (1)"}, accumulateMetric(t, "repeat_rate", cohort, buckets), "should report share of returning orderers")
+	assert.Equal(t, []string{"50.00% retained (2)", "50.00% retained (2)", "75.00% retained (3)"}, accumulateMetric(t, "cumulative_retention", cohort, buckets), "should report customers who have ordered by each bucket")
+	assert.Equal(t, []string{"7.50 ltv (30.00)", "7.50 ltv (30.00)", "22.50 ltv (90.00)"}, accumulateMetric(t, "ltv", cohort, buckets), "should report cumulative revenue per customer")
+	assert.Equal(t, []string{"100.00% nrr (30.00)", "0.00% nrr (0.00)", "200.00% nrr (60.00)"}, accumulateMetric(t, "nrr", cohort, buckets), "should report revenue relative to first bucket")
+}
+
+func TestMixedCurrencies(t *testing.T) {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{1, "2015-06-19T23:49:32"},
+		{2, "2015-06-20T00:09:03"},
+		{3, "2015-07-01T00:09:03"},
+	}, nil)
+	defer cleanup()
+	for _, order := range [][]interface{}{
+		{1, 1, 1, "2015-06-21T10:00:00", 10.0, "USD"},
+		{2, 1, 2, "2015-06-22T10:00:00", 20.0, "EUR"},
+		{3, 1, 3, "2015-07-02T10:00:00", 5.0, "USD"},
+		{4, 2, 3, "2015-07-03T10:00:00", 5.0, ""},
+	} {
+		assert.NoError(t, Insert(db, "orders", []string{"id", "order_number", "user_id", "created", "amount", "currency"}, order), "should insert orders")
+	}
+	config := DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		config.Metrics = []string{"orderers", "ltv"}
+		_, err := Compute(context.Background(), db, config)
+		if assert.Error(t, err, "should fail on revenue of cohorts mixing currencies") {
+			assert.Contains(t, err.Error(), "EUR, USD", "should name the mixed currencies")
+		}
+		config.Metrics = []string{"orderers", "order_count"}
+		_, err = Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should count orders of mixed currencies")
+		config.From = time.Date(2015, 6, 27, 0, 0, 0, 0, time.UTC)
+		config.Metrics = []string{"ltv"}
+		result, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should sum revenue of cohorts holding a single currency")
+		assert.Equal(t, "10.00 ltv (10.00)", result.Rows[0][2], "should sum orders without a currency along with the others")
+		config.From = time.Time{}
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	names, err := ParseMetrics(" orderers, first_time,,ltv ")
+	assert.NoError(t, err, "should parse metrics separated by spaces")
+	assert.Equal(t, []string{"orderers", "first_time", "ltv"}, names, "should trim names and skip empty ones")
+	_, err = ParseMetrics("orderers, churn")
+	assert.Error(t, err, "should fail on unknown metrics")
+	_, err = ParseMetrics(" , ")
+	assert.Error(t, err, "should fail on lists without metrics")
+}
@@ -0,0 +1,125 @@
+package cohort
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Period is a calendar aware unit of time used to window cohorts and to bucket orders
+type Period string
+
+// Periods supported for cohort windows and order buckets
+const (
+	Day     Period = "day"
+	Week    Period = "week"
+	Month   Period = "month"
+	Quarter Period = "quarter"
+	Year    Period = "year"
+)
+
+// ParsePeriod returns the Period matching value or an error if it is not supported
+func ParsePeriod(value string) (Period, error) {
+	switch period := Period(value); period {
+	case Day, Week, Month, Quarter, Year:
+		return period, nil
+	}
+	return "", fmt.Errorf("Unsupported period %q expected one of day, week, month, quarter or year", value)
+}
+
+// days returns the fixed length in days of day and week periods and zero for calendar periods
+func (period Period) days() int {
+	switch period {
+	case Day:
+		return 1
+	case Week:
+		return 7
+	}
+	return 0
+}
+
+// months returns the length in months of calendar periods and zero for day and week periods
+func (period Period) months() int {
+	switch period {
+	case Month:
+		return 1
+	case Quarter:
+		return 3
+	case Year:
+		return 12
+	}
+	return 0
+}
+
+// Truncate returns midnight of the first day of the period containing t, weeks start on the day of t itself
+func (period Period) Truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch period {
+	case Month:
+		day = 1
+	case Quarter:
+		month = month - (month-1)%3
+		day = 1
+	case Year:
+		month = time.January
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Add returns t moved forward by n periods
+func (period Period) Add(t time.Time, n int) time.Time {
+	if months := period.months(); months != 0 {
+		return t.AddDate(0, n*months, 0)
+	}
+	return t.AddDate(0, 0, n*period.days())
+}
+
+// Between returns the number of whole periods elapsed from start until t, negative if t is before start, counted on the wall clock of their locations so days stay whole across daylight saving transitions
+func (period Period) Between(start, t time.Time) int {
+	start, t = wallClock(start), wallClock(t)
+	if months := period.months(); months != 0 {
+		elapsed := (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+		// step back a month when the day of month or time of day has not yet been reached
+		if start.AddDate(0, elapsed, 0).After(t) {
+			elapsed--
+		}
+		return floorDiv(elapsed, months)
+	}
+	// days are floored so orders placed less than a day ahead of start fall before the first period
+	days := int(math.Floor(t.Sub(start).Hours() / 24))
+	return floorDiv(days, period.days())
+}
+
+// Header returns the column header for the bucket at index, day and week buckets are labeled by their day range
+func (period Period) Header(index int) string {
+	if period.days() != 0 {
+		first, last := period.DayRange(time.Time{}, index)
+		return fmt.Sprintf("%d-%d", first, last)
+	}
+	return fmt.Sprintf("%s %d", period, index)
+}
+
+// DayRange returns the first and last day of the bucket at index counted from start, calendar periods depend on start
+func (period Period) DayRange(start time.Time, index int) (int, int) {
+	if length := period.days(); length != 0 {
+		return index * length, (index+1)*length - 1
+	}
+	return daysBetween(start, period.Add(start, index)), daysBetween(start, period.Add(start, index+1)) - 1
+}
+
+// daysBetween returns the number of calendar days from the date of start to the date of end
+func daysBetween(start, end time.Time) int {
+	startYear, startMonth, startDay := start.Date()
+	endYear, endMonth, endDay := end.Date()
+	startDate := time.Date(startYear, startMonth, startDay, 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(endYear, endMonth, endDay, 0, 0, 0, 0, time.UTC)
+	return int(endDate.Sub(startDate).Hours() / 24)
+}
+
+func floorDiv(value, divisor int) int {
+	if value < 0 {
+		return (value - divisor + 1) / divisor
+	}
+	return value / divisor
+}
@@ -0,0 +1,51 @@
+package cohort
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriod(t *testing.T) {
+	_, err := ParsePeriod("fortnight")
+	assert.Error(t, err, "should fail on parsing unsupported period")
+
+	period, err := ParsePeriod("month")
+	assert.NoError(t, err, "should parse supported period")
+	assert.Equal(t, Month, period, "should return matching period")
+
+	created := time.Date(2015, time.January, 31, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2015, time.January, 31, 0, 0, 0, 0, time.UTC), Week.Truncate(created), "should start weeks on the given day")
+	assert.Equal(t, time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC), Month.Truncate(created), "should start months on the first day")
+	assert.Equal(t, time.Date(2015, time.April, 1, 0, 0, 0, 0, time.UTC), Quarter.Truncate(time.Date(2015, time.June, 19, 0, 0, 0, 0, time.UTC)), "should start quarters on the first day of the quarter")
+	assert.Equal(t, time.Date(2015, time.February, 1, 0, 0, 0, 0, time.UTC), Month.Add(Month.Truncate(created), 1), "should add calendar months")
+
+	assert.Equal(t, 0, Week.Between(created, created.Add(6*24*time.Hour)), "should place sixth day in first week")
+	assert.Equal(t, 1, Week.Between(created, created.Add(7*24*time.Hour)), "should place seventh day in second week")
+	assert.Equal(t, -1, Week.Between(created, created.Add(-24*time.Hour)), "should place prior days in negative buckets")
+	assert.Equal(t, -1, Day.Between(created, created.Add(-12*time.Hour)), "should place orders less than a day ahead of signup in negative buckets")
+	assert.Equal(t, 0, Month.Between(created, time.Date(2015, time.February, 28, 10, 0, 0, 0, time.UTC)), "should not complete a month before its real length")
+	assert.Equal(t, 1, Month.Between(created, time.Date(2015, time.March, 10, 10, 0, 0, 0, time.UTC)), "should complete a month after its real length")
+	assert.Equal(t, 1, Quarter.Between(created, time.Date(2015, time.May, 1, 0, 0, 0, 0, time.UTC)), "should count whole quarters")
+
+	// daylight saving time starts in New York on 2015-03-08 making it 23 hours long
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err, "should load timezone data")
+	signup := time.Date(2015, time.March, 7, 23, 30, 0, 0, newYork)
+	assert.Equal(t, 1, Day.Between(signup, time.Date(2015, time.March, 8, 23, 30, 0, 0, newYork)), "should count the shortened day as a whole day")
+	assert.Equal(t, 0, Day.Between(signup, time.Date(2015, time.March, 8, 23, 29, 0, 0, newYork)), "should not complete a day before its wall clock time")
+	assert.Equal(t, 1, Week.Between(signup, time.Date(2015, time.March, 14, 23, 30, 0, 0, newYork)), "should complete a week spanning the transition after seven wall clock days")
+	assert.True(t, time.Date(2015, time.March, 9, 0, 0, 0, 0, newYork).Equal(Day.Add(Day.Truncate(signup.AddDate(0, 0, 1)), 1)), "should end days at local midnight across the transition")
+	assert.Equal(t, 23*time.Hour, Day.Add(time.Date(2015, time.March, 8, 0, 0, 0, 0, newYork), 1).Sub(time.Date(2015, time.March, 8, 0, 0, 0, 0, newYork)), "should shorten the day of the transition")
+	assert.Equal(t, 25*time.Hour, Day.Add(time.Date(2015, time.November, 1, 0, 0, 0, 0, newYork), 1).Sub(time.Date(2015, time.November, 1, 0, 0, 0, 0, newYork)), "should lengthen the day daylight saving time ends")
+
+	assert.Equal(t, "0-6", Week.Header(0), "should label weeks by day range")
+	assert.Equal(t, "3-3", Day.Header(3), "should label days by day range")
+	assert.Equal(t, "month 2", Month.Header(2), "should label calendar periods by index")
+
+	first, last := Week.DayRange(created, 2)
+	assert.Equal(t, []int{14, 20}, []int{first, last}, "should return fixed day range of weeks")
+	first, last = Month.DayRange(time.Date(2015, time.February, 1, 0, 0, 0, 0, time.UTC), 1)
+	assert.Equal(t, []int{28, 58}, []int{first, last}, "should return calendar day range of months from start")
+}
@@ -0,0 +1,130 @@
+package cohort
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrorMode defines how imports handle csv rows that fail validation
+type ErrorMode string
+
+// Error modes supported by imports
+const (
+	// SkipErrors leaves rejected rows out of the import and reports them
+	SkipErrors ErrorMode = "skip"
+	// FailOnError rolls back the import at the first rejected row
+	FailOnError ErrorMode = "fail"
+	// QuarantineErrors leaves rejected rows out of the import and stores them in the rejects table along with reporting them
+	QuarantineErrors ErrorMode = "quarantine"
+)
+
+// ParseErrorMode returns the ErrorMode matching value or an error if it is not supported
+func ParseErrorMode(value string) (ErrorMode, error) {
+	switch mode := ErrorMode(value); mode {
+	case SkipErrors, FailOnError, QuarantineErrors:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Unsupported error mode %q expected one of skip, fail or quarantine", value)
+}
+
+// rejectSchema stores the rows rejected by quarantining imports
+var rejectSchema = map[string]string{
+	"file":      "varchar(512) not null",
+	"tablename": "varchar(64) not null",
+	"line":      "int not null",
+	"reason":    "text not null",
+	"data":      "text not null",
+}
+
+// FieldError identifies a csv value that could not be parsed into the field it is mapped to
+type FieldError struct {
+	Field string
+	Value string
+}
+
+// Error returns an error message identifying the field and its value
+func (err FieldError) Error() string {
+	return fmt.Sprintf("Invalid %s %q", err.Field, err.Value)
+}
+
+// Reject is a csv row rejected by an import along with the line it starts on and the reason it was rejected
+type Reject struct {
+	File   string   `json:"file"`
+	Line   int      `json:"line"`
+	Reason string   `json:"reason"`
+	Row    []string `json:"row"`
+}
+
+// Error returns the location and reason of the rejected row so FailOnError imports can return it
+func (reject Reject) Error() string {
+	return fmt.Sprintf("Rejected line %d of %s with error %s", reject.Line, reject.File, reject.Reason)
+}
+
+// ImportReport counts the rows of a csv accepted and rejected by an import
+type ImportReport struct {
+	File     string `json:"file"`
+	Table    string `json:"table"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+}
+
+// String summarizes the accepted and rejected rows of the import
+func (report ImportReport) String() string {
+	return fmt.Sprintf("Imported %s into %s with %d rows accepted and %d rejected", report.File, report.Table, report.Accepted, report.Rejected)
+}
+
+// reject counts a rejected row and writes it to rejects if set, the returned error is nil unless the import should stop
+func (report *ImportReport) reject(line int, row []string, reason error, mode ErrorMode, rejects *RejectWriter) error {
+	reject := Reject{report.File, line, reason.Error(), row}
+	report.Rejected++
+	if rejects != nil {
+		if err := rejects.Write(reject); err != nil {
+			return err
+		}
+	}
+	if mode == FailOnError {
+		return reject
+	}
+	return nil
+}
+
+// encodeRow formats row as a line of csv without its line break
+func encodeRow(row []string) string {
+	encoded := strings.Builder{}
+	writer := csv.NewWriter(&encoded)
+	writer.Write(row)
+	writer.Flush()
+	return strings.TrimSuffix(encoded.String(), "\n")
+}
+
+// RejectWriter streams rejected rows as csv rows of file, line, reason and the rejected row so imports do not hold them in memory
+type RejectWriter struct {
+	writer *csv.Writer
+	// header is set once the header row is written ahead of the first reject
+	header bool
+}
+
+// NewRejectWriter creates a RejectWriter writing to output
+func NewRejectWriter(output io.Writer) *RejectWriter {
+	return &RejectWriter{writer: csv.NewWriter(output)}
+}
+
+// Write writes reject, preceded by the header row if it is the first
+func (rejects *RejectWriter) Write(reject Reject) error {
+	if !rejects.header {
+		if err := rejects.writer.Write([]string{"file", "line", "reason", "row"}); err != nil {
+			return err
+		}
+		rejects.header = true
+	}
+	return rejects.writer.Write([]string{reject.File, strconv.Itoa(reject.Line), reject.Reason, encodeRow(reject.Row)})
+}
+
+// Flush writes any buffered rejects to the output
+func (rejects *RejectWriter) Flush() error {
+	rejects.writer.Flush()
+	return rejects.writer.Error()
+}
@@ -0,0 +1,101 @@
+package cohort
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportRejects(t *testing.T) {
+	_, err := ParseErrorMode("ignore")
+	assert.Error(t, err, "should fail on parsing unsupported error modes")
+
+	customers := strings.Join([]string{
+		"id,created",
+		"33559,2015-06-19 23:49:32",
+		"abc,2015-06-19 23:49:32",
+		"33563,2015-06-20 00:09:03,extra",
+		"33564,20/06/2015",
+		"33600,2015-06-27 10:00:00",
+		`33601,"2015-06-27 "10:00:00"`,
+		"33602,2015-06-28 10:00:00",
+	}, "\n") + "\n"
+
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	config := DefaultConfig()
+	report, err := ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+	assert.Equal(t, Reject{"customers.csv", 3, `Invalid customer_id "abc"`, []string{"abc", "2015-06-19 23:49:32"}}, err, "should fail on the first rejected row")
+	assert.Equal(t, 1, report.Rejected, "should report the rejected row")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM customers")
+	assert.Equal(t, 0, count, "should roll back failed imports")
+
+	config.OnError = SkipErrors
+	output := bytes.Buffer{}
+	config.Rejects = NewRejectWriter(&output)
+	report, err = ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+	assert.NoError(t, err, "should skip rejected rows")
+	assert.Equal(t, 3, report.Accepted, "should count accepted rows")
+	assert.Equal(t, 4, report.Rejected, "should count rejected rows")
+	assert.NoError(t, config.Rejects.Flush(), "should write rejects")
+	rejects, err := csv.NewReader(&output).ReadAll()
+	assert.NoError(t, err, "should write rejects as csv")
+	assert.Equal(t, []string{"file", "line", "reason", "row"}, rejects[0], "should write a header row")
+	assert.Equal(t, []string{"customers.csv", "3", `Invalid customer_id "abc"`, "abc,2015-06-19 23:49:32"}, rejects[1], "should write every rejected row")
+	lines := []string{}
+	for _, reject := range rejects[1:] {
+		lines = append(lines, reject[1])
+	}
+	assert.Equal(t, []string{"3", "4", "5", "7"}, lines, "should write the line of every rejected row")
+	assert.Equal(t, "Mismatched values in csv wanted 2 got 3", rejects[2][2], "should reject mismatched rows")
+	assert.Equal(t, `Invalid signup_at "20/06/2015"`, rejects[3][2], "should reject unparsable datetimes")
+	config.Rejects = nil
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM customers")
+	assert.Equal(t, 3, count, "should import rows following rejected rows")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM rejects")
+	assert.Equal(t, 0, count, "should not store skipped rows")
+
+	config.OnError = QuarantineErrors
+	config.Incremental = true
+	report, err = ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+	assert.NoError(t, err, "should quarantine rejected rows")
+	assert.Equal(t, 4, report.Rejected, "should count quarantined rows")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM rejects WHERE file = 'customers.csv' AND tablename = 'customers'")
+	assert.Equal(t, 4, count, "should store quarantined rows")
+	count, _ = queryInt(db, "SELECT line FROM rejects WHERE data = '33563,2015-06-20 00:09:03,extra'")
+	assert.Equal(t, 4, count, "should store quarantined rows as csv")
+}
+
+func TestImportConstraintRejects(t *testing.T) {
+	customers := "id,created\n33559,2015-06-19 23:49:32\n33563,2015-06-20 00:09:03\n33559,2015-06-21 10:00:00\n33600,2015-06-27 10:00:00\n"
+	db, cleanup := makeTestSource(t, nil, nil)
+	defer cleanup()
+	config := DefaultConfig()
+	_, err := ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+	assert.Error(t, err, "should fail on duplicate ids")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM customers")
+	assert.Equal(t, 0, count, "should roll back imports failing on duplicate ids")
+
+	for _, mode := range []ErrorMode{SkipErrors, QuarantineErrors} {
+		db, cleanup := makeTestSource(t, nil, nil)
+		defer cleanup()
+		config.OnError = mode
+		output := bytes.Buffer{}
+		config.Rejects = NewRejectWriter(&output)
+		report, err := ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+		assert.NoError(t, err, "should "+string(mode)+" rows refused by the database")
+		assert.Equal(t, 3, report.Accepted, "should count the rows written")
+		assert.Equal(t, 1, report.Rejected, "should count the rows refused by the database")
+		count, _ := queryInt(db, "SELECT COUNT(*) FROM customers")
+		assert.Equal(t, 3, count, "should write the rows of the refused batch one at a time")
+		count, _ = queryInt(db, "SELECT COUNT(*) FROM customers WHERE id = 33559 AND created = '2015-06-19T23:49:32'")
+		assert.Equal(t, 1, count, "should keep the first row of a duplicate id")
+		config.Rejects.Flush()
+		assert.Contains(t, output.String(), "customers.csv,4,", "should write the line of the refused row")
+		count, _ = queryInt(db, "SELECT COUNT(*) FROM rejects WHERE line = 4")
+		assert.Equal(t, map[ErrorMode]int{SkipErrors: 0, QuarantineErrors: 1}[mode], count, "should quarantine refused rows")
+	}
+}
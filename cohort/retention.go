@@ -0,0 +1,51 @@
+package cohort
+
+import "fmt"
+
+// RetentionMode defines which buckets a customer counts as retained in
+type RetentionMode string
+
+// Retention modes supported when aggregating orderers into buckets
+const (
+	// Bracket counts customers in every bucket they ordered in
+	Bracket RetentionMode = "bracket"
+	// Unbounded counts customers in every bucket up to the last bucket they ordered in
+	Unbounded RetentionMode = "unbounded"
+	// Cumulative counts customers in every bucket from the first bucket they ordered in
+	Cumulative RetentionMode = "cumulative"
+)
+
+// ParseRetentionMode returns the RetentionMode matching value or an error if it is not supported
+func ParseRetentionMode(value string) (RetentionMode, error) {
+	switch mode := RetentionMode(value); mode {
+	case Bracket, Unbounded, Cumulative:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Unsupported retention mode %q expected one of bracket, unbounded or cumulative", value)
+}
+
+// retainOrders returns the orders of cohort with the unique orderers of every bucket replaced by the customers retained in it
+func retainOrders(cohort Cohort, mode RetentionMode) map[int]Orders {
+	if mode == Bracket || mode == "" {
+		return cohort.Orders
+	}
+	retained := make(map[int]Orders, cohort.MaxBucket+1)
+	customers := make(map[string]bool)
+	for i := 0; i <= cohort.MaxBucket; i++ {
+		// walk buckets backwards for unbounded retention so later orders carry over to earlier buckets
+		bucket := i
+		if mode == Unbounded {
+			bucket = cohort.MaxBucket - i
+		}
+		orders := cohort.Orders[bucket]
+		for customer := range orders.UniqueOrders {
+			customers[customer] = true
+		}
+		orders.UniqueOrders = make(map[string]bool, len(customers))
+		for customer := range customers {
+			orders.UniqueOrders[customer] = true
+		}
+		retained[bucket] = orders
+	}
+	return retained
+}
@@ -0,0 +1,33 @@
+package cohort
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetainOrders(t *testing.T) {
+	_, err := ParseRetentionMode("rolling")
+	assert.Error(t, err, "should fail on parsing unsupported retention mode")
+
+	cohort := Cohort{
+		MaxBucket: 3,
+		Orders: map[int]Orders{
+			0: {map[string]bool{"1": true}, 1, 1, 0},
+			2: {map[string]bool{"2": true}, 1, 1, 0},
+			3: {map[string]bool{"1": true}, 0, 1, 0},
+		},
+	}
+	orderers := func(orders map[int]Orders) []int {
+		counts := []int{}
+		for bucket := 0; bucket <= cohort.MaxBucket; bucket++ {
+			counts = append(counts, len(orders[bucket].UniqueOrders))
+		}
+		return counts
+	}
+
+	assert.Equal(t, []int{1, 0, 1, 1}, orderers(retainOrders(cohort, Bracket)), "should count customers only in buckets they ordered in")
+	assert.Equal(t, []int{2, 2, 2, 1}, orderers(retainOrders(cohort, Unbounded)), "should count customers in buckets up to their last order")
+	assert.Equal(t, []int{1, 1, 2, 2}, orderers(retainOrders(cohort, Cumulative)), "should count customers in buckets from their first order")
+	assert.Equal(t, 1, retainOrders(cohort, Cumulative)[2].FirstTimeOrders, "should keep first time orders untouched")
+}
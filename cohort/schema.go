@@ -0,0 +1,151 @@
+package cohort
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Schema names the tables and columns cohorts are computed from, imports always write to the tables of DefaultSchema
+type Schema struct {
+	CustomersTable  string
+	CustomerID      string
+	CustomerCreated string
+	OrdersTable     string
+	// OrderCustomer references the customer id of an order
+	OrderCustomer string
+	OrderCreated  string
+	// OrderAmount is optional, orders are counted without revenue when it is empty
+	OrderAmount string
+	// OrderCurrency is optional, revenue is only checked to share a currency when it is set
+	OrderCurrency string
+}
+
+// DefaultSchema returns the Schema of the customers and orders tables created by MakeTables
+func DefaultSchema() Schema {
+	return Schema{
+		CustomersTable:  "customers",
+		CustomerID:      "id",
+		CustomerCreated: "created",
+		OrdersTable:     "orders",
+		OrderCustomer:   "user_id",
+		OrderCreated:    "created",
+		OrderAmount:     "amount",
+		OrderCurrency:   "currency",
+	}
+}
+
+var (
+	tablePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	columnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Validate checks every table and column is a plain identifier, tables may be qualified by a schema, so mapped names cannot inject sql
+func (schema Schema) Validate() error {
+	for name, table := range map[string]string{
+		"customers table": schema.CustomersTable,
+		"orders table":    schema.OrdersTable,
+	} {
+		if !tablePattern.MatchString(table) {
+			return fmt.Errorf("Invalid %s %q expected a table name", name, table)
+		}
+	}
+	columns := map[string]string{
+		"customer id column":      schema.CustomerID,
+		"customer created column": schema.CustomerCreated,
+		"order customer column":   schema.OrderCustomer,
+		"order created column":    schema.OrderCreated,
+	}
+	if schema.OrderAmount != "" {
+		columns["order amount column"] = schema.OrderAmount
+	}
+	if schema.OrderCurrency != "" {
+		columns["order currency column"] = schema.OrderCurrency
+	}
+	for name, column := range columns {
+		if !columnPattern.MatchString(column) {
+			return fmt.Errorf("Invalid %s %q expected a column name", name, column)
+		}
+	}
+	return nil
+}
+
+// CohortBy defines the datetime customers join their cohort at
+type CohortBy string
+
+// Cohort memberships supported when grouping customers into cohorts
+const (
+	// SignupCohorts groups every customer by their creation and measures buckets from it
+	SignupCohorts CohortBy = "signup"
+	// FirstOrderCohorts groups buyers by their first order and measures buckets from it, customers are read from the orders table alone
+	FirstOrderCohorts CohortBy = "first_order"
+)
+
+// ParseCohortBy returns the CohortBy matching value or an error if it is not supported
+func ParseCohortBy(value string) (CohortBy, error) {
+	switch cohortBy := CohortBy(value); cohortBy {
+	case SignupCohorts, FirstOrderCohorts:
+		return cohortBy, nil
+	}
+	return "", fmt.Errorf("Unsupported cohort membership %q expected one of signup or first_order", value)
+}
+
+// members returns schema with customers read as cohortBy defines them, first order cohorts replace the customers table by the first order of every buyer along with the attribute columns of their customer so the queries of both engines read either alike
+func (schema Schema) members(cohortBy CohortBy, attributes ...string) Schema {
+	if cohortBy != FirstOrderCohorts {
+		return schema
+	}
+	members := schema
+	if len(attributes) == 0 {
+		members.CustomersTable = fmt.Sprintf("(SELECT %[1]s AS id, MIN(%[2]s) AS created FROM %[3]s WHERE %[2]s IS NOT NULL GROUP BY %[1]s) first_orders", schema.OrderCustomer, schema.OrderCreated, schema.OrdersTable)
+	} else {
+		// buyers missing from the customers table have null attributes
+		columns := strings.Builder{}
+		for _, attribute := range attributes {
+			columns.WriteString(fmt.Sprintf(", MAX(customers.%[1]s) AS %[1]s", attribute))
+		}
+		members.CustomersTable = fmt.Sprintf("(SELECT orders.%[1]s AS id, MIN(orders.%[2]s) AS created%[4]s FROM %[3]s orders LEFT JOIN %[5]s customers ON customers.%[6]s = orders.%[1]s WHERE orders.%[2]s IS NOT NULL GROUP BY orders.%[1]s) first_orders",
+			schema.OrderCustomer, schema.OrderCreated, schema.OrdersTable, columns.String(), schema.CustomersTable, schema.CustomerID)
+	}
+	members.CustomerID = "id"
+	members.CustomerCreated = "created"
+	return members
+}
+
+// customerColumns selects the id and creation timestamp of customers
+func (schema Schema) customerColumns(dialect Dialect) []string {
+	return []string{schema.CustomerID, dialect.Timestamp(schema.CustomerCreated)}
+}
+
+// customerWindow is a condition on customers created from start until the exclusive end
+func (schema Schema) customerWindow(dialect Dialect, start, end time.Time) Condition {
+	created := dialect.Datetime(schema.CustomerCreated)
+	return func(cond *sqlbuilder.Cond) string {
+		return cond.And(cond.GreaterEqualThan(created, formatStored(start)), cond.LessThan(created, formatStored(end)))
+	}
+}
+
+// orderAmount selects the amount of an order or zero if orders have no amount column
+func (schema Schema) orderAmount(table string) string {
+	if schema.OrderAmount == "" {
+		return "0"
+	}
+	if table != "" {
+		return fmt.Sprintf("COALESCE(%s.%s, 0)", table, schema.OrderAmount)
+	}
+	return fmt.Sprintf("COALESCE(%s, 0)", schema.OrderAmount)
+}
+
+// orderCurrency selects the currency of an order or an empty string if orders have no currency column
+func (schema Schema) orderCurrency(table string) string {
+	if schema.OrderCurrency == "" {
+		return "''"
+	}
+	if table != "" {
+		return fmt.Sprintf("COALESCE(%s.%s, '')", table, schema.OrderCurrency)
+	}
+	return fmt.Sprintf("COALESCE(%s, '')", schema.OrderCurrency)
+}
@@ -0,0 +1,99 @@
+package cohort
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	db, cleanup := makeTestSource(t, [][]interface{}{
+		{33559, "2015-06-19T23:49:32"},
+		{33563, "2015-06-20T00:09:03"},
+		{33600, "2015-06-27T10:00:00"},
+	}, [][]interface{}{
+		{26444, 1, 33563, "2015-06-25T01:27:40", 10.0},
+		{26445, 2, 33563, "2015-06-26T01:27:40", 30.0},
+		{26446, 1, 33559, "2015-07-04T10:00:00", 20.0},
+	})
+	defer cleanup()
+	// copy the imported rows into tables named like an existing application database
+	for _, statement := range []string{
+		"CREATE TABLE accounts (account_id integer primary key, signed_up datetime)",
+		"INSERT INTO accounts SELECT id, created FROM customers",
+		"INSERT INTO accounts VALUES (40000, NULL)",
+		"CREATE TABLE purchases (purchase_id integer primary key, account integer, placed_at datetime, total real)",
+		"INSERT INTO purchases SELECT id, user_id, created, amount FROM orders",
+		"INSERT INTO purchases VALUES (30000, 33600, '2015-06-28T00:00:00', NULL)",
+		"INSERT INTO orders (id, order_number, user_id, created, amount) VALUES (30000, 3, 33600, '2015-06-28T00:00:00', 0)",
+		// an application writing datetimes with a space separator
+		"CREATE TABLE spaced_accounts AS SELECT account_id, replace(signed_up, 'T', ' ') AS signed_up FROM accounts",
+		"CREATE TABLE spaced_purchases AS SELECT purchase_id, account, replace(placed_at, 'T', ' ') AS placed_at, total FROM purchases",
+	} {
+		_, err := db.Exec(statement)
+		assert.NoError(t, err, "should create mapped tables")
+	}
+	schema := Schema{
+		CustomersTable:  "accounts",
+		CustomerID:      "account_id",
+		CustomerCreated: "signed_up",
+		OrdersTable:     "purchases",
+		OrderCustomer:   "account",
+		OrderCreated:    "placed_at",
+		OrderAmount:     "total",
+	}
+	assert.NoError(t, schema.Validate(), "should accept plain identifiers")
+
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config := DefaultConfig()
+		config.Engine = engine
+		config.Metrics = MetricNames()
+		expected, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts from the default tables")
+		config.Schema = schema
+		mapped, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts from mapped tables")
+		assert.Equal(t, expected.Headers, mapped.Headers, "should read identical headers from mapped tables with the "+string(engine)+" engine")
+		assert.Equal(t, expected.Rows, mapped.Rows, "should read identical rows from mapped tables with the "+string(engine)+" engine")
+
+		config.Schema.CustomersTable, config.Schema.OrdersTable = "spaced_accounts", "spaced_purchases"
+		spaced, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts from space separated datetimes")
+		assert.Equal(t, expected.Rows, spaced.Rows, "should read identical rows from space separated datetimes with the "+string(engine)+" engine")
+		config.Location, _ = time.LoadLocation("America/New_York")
+		config.CustomerFilter, _ = ParseFilter("signed_up >= '2015-06-19 19:00:00'")
+		spaced, err = Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute filtered cohorts from space separated datetimes in a timezone")
+		config.Schema, config.CustomerFilter = schema, Filter{}
+		localized, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts from mapped tables in a timezone")
+		assert.Equal(t, localized.Rows, spaced.Rows, "should shift and filter space separated datetimes like stored ones with the "+string(engine)+" engine")
+		config.Location = time.UTC
+
+		config.Schema.OrderAmount = ""
+		unpriced, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute cohorts without an amount column")
+		for _, cohort := range unpriced.Cohorts {
+			for _, orders := range cohort.Orders {
+				assert.Zero(t, orders.Revenue, "should not aggregate revenue without an amount column")
+			}
+		}
+	}
+
+	invalid := schema
+	invalid.OrdersTable = "purchases; DROP TABLE accounts"
+	assert.Error(t, invalid.Validate(), "should reject table names that are not identifiers")
+	invalid = schema
+	invalid.CustomerCreated = "accounts.signed_up"
+	assert.Error(t, invalid.Validate(), "should reject qualified column names")
+	config := DefaultConfig()
+	config.Schema = invalid
+	_, err := Compute(context.Background(), db, config)
+	assert.Error(t, err, "should not compute cohorts of invalid schemas")
+	config.Schema = schema
+	config.Incremental = true
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should not cache cohorts of mapped schemas")
+}
@@ -0,0 +1,181 @@
+package cohort
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Segment is the result of the customers holding Value in the SegmentBy column, Value is empty for customers without one
+type Segment struct {
+	Value string
+	Result
+}
+
+// ParseSegmentBy returns value if it names a column customers can be segmented by or an error otherwise
+func ParseSegmentBy(value string) (string, error) {
+	if !columnPattern.MatchString(value) {
+		return "", fmt.Errorf("Invalid segment column %q expected a column name", value)
+	}
+	return value, nil
+}
+
+// segment restricts cohorts to the customers holding a value in a column, null values form a segment of their own
+type segment struct {
+	column string
+	value  sql.NullString
+}
+
+// condition returns a condition on the customers of the segment
+func (segment segment) condition() Condition {
+	return func(cond *sqlbuilder.Cond) string {
+		if !segment.value.Valid {
+			return cond.IsNull(segment.column)
+		}
+		return cond.Equal(segment.column, segment.value.String)
+	}
+}
+
+// id identifies the segment in cohort cache ids
+func (segment segment) id() string {
+	if !segment.value.Valid {
+		return segment.column + " IS NULL"
+	}
+	return segment.column + "=" + segment.value.String
+}
+
+// segmentValues returns the distinct values of column held by the customers of schema matching filter in ascending order, null first
+func segmentValues(db Executor, schema Schema, column string, filter Filter) ([]sql.NullString, error) {
+	builder := dialectOf(db).Flavor().NewSelectBuilder()
+	builder.Select(column).Distinct().From(schema.CustomersTable)
+	if !filter.IsZero() {
+		builder.Where(filter.condition("")(&builder.Cond))
+	}
+	statement, args := builder.Build()
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []sql.NullString{}
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	// sort in go as databases order nulls and collate strings differently
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Valid != values[j].Valid {
+			return !values[i].Valid
+		}
+		return values[i].String < values[j].String
+	})
+	return values, rows.Err()
+}
+
+// computeSegments computes a result per value of the SegmentBy column, every segment shares the cohort windows of all customers
+func computeSegments(ctx context.Context, source SQL, config Config) (Result, error) {
+	result := Result{Config: config}
+	if _, err := ParseSegmentBy(config.SegmentBy); err != nil {
+		return result, err
+	}
+	values, err := segmentValues(source, config.Schema.members(config.cohortBy(), config.attributes()...), config.SegmentBy, config.CustomerFilter)
+	if err != nil {
+		return result, fmt.Errorf("Failed to read segments of column %s with error %s", config.SegmentBy, err.Error())
+	}
+	for _, value := range values {
+		segmentConfig := config
+		segmentConfig.segment = &segment{config.SegmentBy, value}
+		segmentResult, err := computeResult(ctx, source, segmentConfig)
+		if err != nil {
+			return result, err
+		}
+		result.Segments = append(result.Segments, Segment{value.String, segmentResult})
+		// label buckets up to the widest segment
+		if len(segmentResult.Buckets) > len(result.Buckets) {
+			result.Buckets = segmentResult.Buckets
+		}
+		result.ObservedUntil = segmentResult.ObservedUntil
+	}
+	return result, nil
+}
+
+// segmented reports whether result holds the results of its segments rather than cohorts of its own
+func (result Result) segmented() bool {
+	return result.Config.SegmentBy != "" && result.Config.segment == nil
+}
+
+// segments returns the segments of result or result itself as the single unnamed segment of unsegmented results
+func (result Result) segments() []Segment {
+	if !result.segmented() {
+		return []Segment{{"", result}}
+	}
+	return result.Segments
+}
+
+// attributeColumn returns the column an unmapped csv header is stored in, lowercase with every other character replaced by an underscore, or an empty string if no letter or digit remains
+func attributeColumn(header string) string {
+	column := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, strings.TrimSpace(header))
+	if strings.Trim(column, "_") == "" {
+		return ""
+	}
+	if column[0] >= '0' && column[0] <= '9' {
+		column = "_" + column
+	}
+	return column
+}
+
+// attributeColumns adds a column to table for every csv header not mapped to one of its columns, and returns the attribute columns along with the index of their header
+func attributeColumns(db Executor, table importTable, headers []string, mapping ColumnMapping) ([]string, []int, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", table.name))
+	if err != nil {
+		return nil, nil, err
+	}
+	existing, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := map[string]bool{}
+	for _, column := range existing {
+		stored[strings.ToLower(column)] = true
+	}
+	skipped := map[string]bool{}
+	for _, header := range table.headers(mapping) {
+		skipped[header] = true
+	}
+	for _, column := range table.columns {
+		skipped[column] = true
+	}
+	columns, indexes := []string{}, []int{}
+	for i, header := range headers {
+		column := attributeColumn(header)
+		if skipped[header] || column == "" || skipped[column] {
+			continue
+		}
+		// repeated headers are stored once
+		skipped[column] = true
+		if !stored[column] {
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, column, dialectOf(db).ColumnType("varchar(255)"))); err != nil {
+				return nil, nil, fmt.Errorf("Failed to add attribute column %s with error %s", column, err.Error())
+			}
+		}
+		columns = append(columns, column)
+		indexes = append(indexes, i)
+	}
+	return columns, indexes, nil
+}
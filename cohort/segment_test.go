@@ -0,0 +1,131 @@
+package cohort
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributeColumn(t *testing.T) {
+	assert.Equal(t, "country", attributeColumn("country"), "should keep plain headers")
+	assert.Equal(t, "acquisition_channel", attributeColumn(" Acquisition Channel "), "should lowercase headers and replace other characters")
+	assert.Equal(t, "_2fa", attributeColumn("2fa"), "should prefix headers starting with a digit")
+	assert.Equal(t, "", attributeColumn("--"), "should skip headers without letters or digits")
+	_, err := ParseSegmentBy("country; DROP TABLE customers")
+	assert.Error(t, err, "should reject segment columns that are not column names")
+}
+
+func TestSegments(t *testing.T) {
+	db, cleanup := makeTestSource(t, nil, [][]interface{}{
+		{1, 1, 1, "2015-06-25T01:27:40", 10.0},
+		{2, 1, 2, "2015-06-21T10:00:00", 20.0},
+		{3, 2, 2, "2015-07-01T10:00:00", 20.0},
+		{4, 1, 5, "2015-06-30T10:00:00", 5.0},
+	})
+	defer cleanup()
+	config := DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	customers := writeTestCSV(t, "id,created,Country,plan\n1,2015-06-19 23:49:32,US,free\n2,2015-06-20 00:09:03,UK,\n3,2015-06-21 00:09:03,US,pro\n4,2015-06-22 00:09:03,,pro\n")
+	defer os.Remove(customers)
+	_, err := ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should import customers with attribute columns")
+	count, _ := queryInt(db, "SELECT COUNT(*) FROM customers WHERE country = 'US' AND plan = 'pro'")
+	assert.Equal(t, 1, count, "should store unmapped headers as attribute columns")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM customers WHERE plan IS NULL")
+	assert.Equal(t, 1, count, "should store empty attributes as null")
+
+	// later imports add new attribute columns and keep existing ones
+	customers = writeTestCSV(t, "id,created,country,channel\n5,2015-06-23 00:09:03,UK,ads\n")
+	defer os.Remove(customers)
+	_, err = ImportCustomers(db, customers, config)
+	assert.NoError(t, err, "should import customers with other attribute columns")
+	count, _ = queryInt(db, "SELECT COUNT(*) FROM customers WHERE country = 'UK' AND channel = 'ads' AND plan IS NULL")
+	assert.Equal(t, 1, count, "should add attribute columns missing from the table")
+
+	// attribute columns are added outside of the import transaction as mysql commits schema changes implicitly
+	customers = writeTestCSV(t, "id,created,referrer\n6,2015-06-24 00:09:03,blog\nabc,2015-06-24 00:09:03,blog\n")
+	defer os.Remove(customers)
+	_, err = ImportCustomers(db, customers, config)
+	assert.Error(t, err, "should fail on rejected rows")
+	count, err = queryInt(db, "SELECT COUNT(referrer) FROM customers")
+	assert.NoError(t, err, "should keep attribute columns added ahead of failed imports")
+	assert.Equal(t, 0, count, "should roll back the rows of failed imports")
+
+	config.SegmentBy = "country"
+	for _, engine := range []Engine{MemoryEngine, SQLEngine} {
+		config.Engine = engine
+		result, err := Compute(context.Background(), db, config)
+		assert.NoError(t, err, "should compute segmented cohorts without error")
+		assert.Empty(t, result.Cohorts, "should hold cohorts in segments only")
+		if !assert.Equal(t, 3, len(result.Segments), "should compute a result per segment value") {
+			continue
+		}
+		assert.Equal(t, []string{"", "UK", "US"}, []string{result.Segments[0].Value, result.Segments[1].Value, result.Segments[2].Value}, "should order segments by value with missing values first")
+		uk := result.Segments[1]
+		assert.Equal(t, 1, len(uk.Cohorts), "should share the cohort windows of all customers")
+		assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)", "100.00% orderers (2)"}, uk.Rows[0], "should compute the cohorts of segment customers alone")
+		us := result.Segments[2]
+		assert.Equal(t, []string{"06/19/2015-06/25/2015", "2 customers", "50.00% orderers (1)"}, us.Rows[0], "should bucket orders of every segment separately")
+		assert.Equal(t, []string{"0-6", "7-13"}, result.Buckets, "should label buckets up to the widest segment")
+	}
+
+	config.Engine = MemoryEngine
+	result, err := Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute segmented cohorts without error")
+	exporter, _ := NewExporter("csv")
+	output := bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export segmented csv without error")
+	reader := csv.NewReader(&output)
+	// segment rows are narrower than the matrices following them
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	assert.NoError(t, err, "should write valid csv")
+	assert.Equal(t, []string{"country", ""}, rows[0], "should name the segment of every matrix")
+	assert.Equal(t, []string{"Cohort", "Customers", "0-6"}, rows[1], "should write the header row of every segment")
+
+	exporter, _ = NewExporter("tidy")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export segmented tidy csv without error")
+	rows, err = csv.NewReader(&output).ReadAll()
+	assert.NoError(t, err, "should write valid csv")
+	assert.Equal(t, append([]string{"segment"}, tidyHeaders...), rows[0], "should lead tidy headers with the segment")
+	assert.Equal(t, []string{"UK", "2015-06-19", "2015-06-25", "2", "0", "6", "orderers", "1", "0.5"}, rows[3], "should lead tidy rows with the segment value")
+
+	exporter, _ = NewExporter("json")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export segmented json without error")
+	structured := jsonResult{}
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &structured), "should write valid json")
+	assert.Equal(t, "country", structured.SegmentBy, "should write the segment column")
+	assert.Equal(t, 3, len(structured.Segments), "should write every segment")
+	assert.Equal(t, 2, structured.Segments[2].Cohorts[0].Customers, "should write the cohorts of every segment")
+
+	exporter, _ = NewExporter("svg")
+	output = bytes.Buffer{}
+	assert.NoError(t, exporter.Export(&output, result), "should export segmented svg without error")
+	assert.Contains(t, output.String(), "country = UK", "should label the heatmap of every segment")
+	assert.Contains(t, output.String(), "<svg y=", "should nest the heatmap of every segment")
+	assert.Equal(t, 1, strings.Count(output.String(), `id="legend"`), "should define the legend gradient once")
+
+	config.CohortBy = FirstOrderCohorts
+	config.SegmentBy = "plan"
+	result, err = Compute(context.Background(), db, config)
+	assert.NoError(t, err, "should compute segmented first order cohorts without error")
+	values := []string{}
+	for _, segment := range result.Segments {
+		values = append(values, segment.Value)
+	}
+	assert.Equal(t, []string{"", "free"}, values, "should segment buyers by the attributes of their customer")
+
+	config.SegmentBy = "missing"
+	_, err = Compute(context.Background(), db, config)
+	assert.Error(t, err, "should fail on segment columns missing from the customers table")
+}
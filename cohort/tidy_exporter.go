@@ -0,0 +1,68 @@
+package cohort
+
+import (
+	"io"
+	"strconv"
+)
+
+// TidyExporter writes long csv with a row per cohort, bucket and metric holding plain numbers
+type TidyExporter struct{}
+
+var tidyHeaders = []string{"cohort_start", "cohort_end", "cohort_size", "bucket_start_day", "bucket_end_day", "metric", "count", "rate"}
+
+// Export writes a row per cohort, bucket and metric in chronological order
+func (exporter TidyExporter) Export(output io.Writer, result Result) error {
+	if exporter, ok, err := (CSVExporter{}).Open(output); ok {
+		headers := tidyHeaders
+		// segmented results lead every row with the segment value
+		if result.segmented() {
+			headers = append([]string{"segment"}, tidyHeaders...)
+		}
+		if err := exporter.Write(headers); err != nil {
+			return err
+		}
+		for _, segment := range result.segments() {
+			for _, cohort := range segment.Cohorts {
+				start := cohort.Start.Format("2006-01-02")
+				end := cohort.End.AddDate(0, 0, -1).Format("2006-01-02")
+				size := strconv.Itoa(len(cohort.Customers))
+				for bucket := 0; bucket <= cohort.MaxBucket; bucket++ {
+					// calendar bucket days are counted from the cohort start
+					first, last := result.Config.bucketing().DayRange(cohort.Start, bucket)
+					// the open ended last of explicit buckets has no end day
+					lastDay := strconv.Itoa(last)
+					if last < first {
+						lastDay = ""
+					}
+					for _, values := range cohort.Metrics {
+						value := values.Values[bucket]
+						count, rate := strconv.FormatFloat(value.Count, 'f', -1, 64), strconv.FormatFloat(value.Rate, 'f', -1, 64)
+						// buckets not observed yet hold NA
+						if cohort.Censored(bucket) {
+							count, rate = CensoredCell, CensoredCell
+						}
+						row := []string{
+							start,
+							end,
+							size,
+							strconv.Itoa(first),
+							lastDay,
+							values.Metric.Name(),
+							count,
+							rate,
+						}
+						if result.segmented() {
+							row = append([]string{segment.Value}, row...)
+						}
+						if err := exporter.Write(row); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	} else {
+		return err
+	}
+	return nil
+}
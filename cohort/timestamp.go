@@ -0,0 +1,102 @@
+package cohort
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDatetimeLayout is the layout of datetimes in the bundled csv files
+const DefaultDatetimeLayout = "2006-01-02 15:04:05"
+
+// storedLayout is the layout datetimes are stored in, always as UTC instants without an offset
+const storedLayout = "2006-01-02T15:04:05"
+
+// formatStored formats t as the UTC instant stored in and compared against by the database
+func formatStored(t time.Time) string {
+	return t.UTC().Format(storedLayout)
+}
+
+// parseStored parses a datetime scanned through Dialect.Timestamp as the instant it stores, shown in location
+func parseStored(value string, location *time.Location) time.Time {
+	datetime, _ := time.Parse(storedLayout+"Z", value)
+	return datetime.In(location)
+}
+
+// wallClock returns the date and time of day t shows in its location as a UTC time, so durations between wall clocks ignore daylight saving shifts
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+	return time.Date(year, month, day, hour, minute, second, t.Nanosecond(), time.UTC)
+}
+
+// TimestampParser parses the datetimes of imported csv files by trying its layouts in order ahead of the built in detectors
+type TimestampParser struct {
+	// Layouts are time.Parse layouts, values parsed without an offset are read as UTC
+	Layouts []string
+	// Detect tries RFC3339 and ISO-8601 datetimes, epoch seconds and milliseconds and date only values once no layout matched
+	Detect bool
+}
+
+// NewTimestampParser creates a TimestampParser trying layouts in order, optionally followed by the built in detectors
+func NewTimestampParser(detect bool, layouts ...string) TimestampParser {
+	parser := TimestampParser{Detect: detect}
+	for _, layout := range layouts {
+		// values used to have " UTC" appended ahead of parsing so layouts ending in it match values without it
+		parser.Layouts = append(parser.Layouts, strings.TrimSuffix(layout, " UTC"))
+	}
+	return parser
+}
+
+// DefaultTimestampParser returns the TimestampParser of the bundled csv files with detection enabled
+func DefaultTimestampParser() TimestampParser {
+	return NewTimestampParser(true, DefaultDatetimeLayout)
+}
+
+// isZero reports whether parser was created without NewTimestampParser and can parse nothing
+func (parser TimestampParser) isZero() bool {
+	return len(parser.Layouts) == 0 && !parser.Detect
+}
+
+// detectedLayouts are the layouts tried by detection, in order of specificity
+var detectedLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+var errUnknownTimestamp = errors.New("unknown timestamp format")
+
+// Parse parses value with the first layout or detector matching it, explicit offsets are kept in the returned time
+func (parser TimestampParser) Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range parser.Layouts {
+		if datetime, err := time.Parse(layout, value); err == nil {
+			return datetime, nil
+		}
+	}
+	if !parser.Detect {
+		return time.Time{}, errUnknownTimestamp
+	}
+	for _, layout := range detectedLayouts {
+		if datetime, err := time.Parse(layout, value); err == nil {
+			return datetime, nil
+		}
+	}
+	return parseEpoch(value)
+}
+
+// parseEpoch parses integer seconds since the unix epoch, values of 12 digits or more are read as milliseconds
+func parseEpoch(value string) (time.Time, error) {
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errUnknownTimestamp
+	}
+	if len(strings.TrimPrefix(value, "-")) >= 12 {
+		return time.Unix(0, epoch*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Unix(epoch, 0).UTC(), nil
+}
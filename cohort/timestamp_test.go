@@ -0,0 +1,51 @@
+package cohort
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampParser(t *testing.T) {
+	expected := time.Date(2015, 6, 19, 23, 49, 32, 0, time.UTC)
+	parser := DefaultTimestampParser()
+	for _, value := range []string{
+		"2015-06-19 23:49:32",
+		"2015-06-19T23:49:32Z",
+		"2015-06-20T01:49:32+02:00",
+		"2015-06-19T19:49:32.000-04:00",
+		"2015-06-19T23:49:32",
+		"1434757772",
+		"1434757772000",
+	} {
+		datetime, err := parser.Parse(value)
+		assert.NoError(t, err, "should parse "+value)
+		assert.True(t, expected.Equal(datetime), "should honor the offset of "+value)
+	}
+	datetime, err := parser.Parse("2015-06-19")
+	assert.NoError(t, err, "should detect date only values")
+	assert.Equal(t, time.Date(2015, 6, 19, 0, 0, 0, 0, time.UTC), datetime, "should read date only values as midnight UTC")
+
+	parser = NewTimestampParser(false, "02/01/2006 15:04", "2006-01-02 15:04:05 UTC")
+	datetime, err = parser.Parse("19/06/2015 23:49")
+	assert.NoError(t, err, "should parse values with any layout")
+	assert.Equal(t, time.Date(2015, 6, 19, 23, 49, 0, 0, time.UTC), datetime, "should read values without an offset as UTC")
+	datetime, err = parser.Parse("2015-06-19 23:49:32")
+	assert.NoError(t, err, "should match layouts ending in UTC without it")
+	assert.Equal(t, expected, datetime, "should parse layouts ending in UTC")
+	_, err = parser.Parse("1434757772")
+	assert.Error(t, err, "should not detect formats when detection is disabled")
+
+	config := DefaultConfig()
+	config.Location, _ = time.LoadLocation("America/New_York")
+	config.OrderTimestamps = NewTimestampParser(false, time.RFC3339)
+	customer, err := makeCustomerImportTransformer(config)([]string{"id", "created"}, []string{"33559", "1434757772"})
+	assert.NoError(t, err, "should import customers with epoch datetimes")
+	assert.Equal(t, "2015-06-19T23:49:32", customer["created"], "should store customer datetimes as UTC instants regardless of the location")
+	order, err := makeOrderImportTransformer(config)([]string{"id", "user_id", "created"}, []string{"26444", "33559", "2015-06-20T01:49:32+02:00"})
+	assert.NoError(t, err, "should import orders with their own format")
+	assert.Equal(t, "2015-06-19T23:49:32", order["created"], "should store order datetimes as UTC instants")
+	_, err = makeOrderImportTransformer(config)([]string{"id", "user_id", "created"}, []string{"26444", "33559", "1434757772"})
+	assert.Error(t, err, "should reject order datetimes not matching the order format")
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"projects/cohort-analysis/cohort"
+)
+
+// fileConfig describes a full run in a yaml file, every value sets the flag of the same meaning unless it is passed on the command line
+type fileConfig struct {
+	Source sourceConfig `yaml:"source"`
+	// Columns maps imported csv headers, fields left out keep their default header
+	Columns       cohort.ColumnMapping `yaml:"columns"`
+	Timestamps    timestampsConfig     `yaml:"timestamps"`
+	Timezone      string               `yaml:"timezone"`
+	CohortPeriod  string               `yaml:"cohortPeriod"`
+	CohortBy      string               `yaml:"cohortBy"`
+	SegmentBy     string               `yaml:"segmentBy"`
+	BucketPeriod  string               `yaml:"bucketPeriod"`
+	Buckets       []int                `yaml:"buckets"`
+	RetentionMode string               `yaml:"retentionMode"`
+	Metrics       []string             `yaml:"metrics"`
+	Revenue       *bool                `yaml:"revenue"`
+	Engine        string               `yaml:"engine"`
+	Workers       *int                 `yaml:"workers"`
+	Filters       filtersConfig        `yaml:"filters"`
+	Output        outputConfig         `yaml:"output"`
+	Addr          string               `yaml:"addr"`
+}
+
+// sourceConfig describes where customers and orders are imported from and stored
+type sourceConfig struct {
+	Type   string `yaml:"type"`
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+	DB     string `yaml:"db"`
+	// Customers is left empty to import orders alone for first order cohorts
+	Customers   *string `yaml:"customers"`
+	Orders      string  `yaml:"orders"`
+	Import      *bool   `yaml:"import"`
+	Incremental *bool   `yaml:"incremental"`
+	BatchSize   *int    `yaml:"batchSize"`
+	OnError     string  `yaml:"onError"`
+	// Tables maps the schema read with source type db
+	Tables tablesConfig `yaml:"tables"`
+}
+
+type tablesConfig struct {
+	Customers       string  `yaml:"customers"`
+	CustomerID      string  `yaml:"customer_id"`
+	CustomerCreated string  `yaml:"customer_created"`
+	Orders          string  `yaml:"orders"`
+	OrderCustomer   string  `yaml:"order_customer"`
+	OrderCreated    string  `yaml:"order_created"`
+	OrderAmount     *string `yaml:"order_amount"`
+	OrderCurrency   string  `yaml:"order_currency"`
+}
+
+// timestampsConfig lists the layouts imported datetimes are parsed with, customers and orders default to the shared layouts
+type timestampsConfig struct {
+	Layouts   []string `yaml:"layouts"`
+	Customers []string `yaml:"customers"`
+	Orders    []string `yaml:"orders"`
+	Detect    *bool    `yaml:"detect"`
+}
+
+type filtersConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// ObservedUntil is the date the data was extracted on, buckets ending after it are censored
+	ObservedUntil string `yaml:"observedUntil"`
+	// Customers and Orders are filter expressions the rows of either table are restricted to
+	Customers string `yaml:"customers"`
+	Orders    string `yaml:"orders"`
+}
+
+type outputConfig struct {
+	Path    string `yaml:"path"`
+	Stdout  *bool  `yaml:"stdout"`
+	Format  string `yaml:"format"`
+	Rejects string `yaml:"rejects"`
+}
+
+// configValue is a flag value set by a config file along with the keys locating it in the file
+type configValue struct {
+	flag  string
+	value string
+	path  string
+}
+
+// values lists the flag values set by the file in the order they appear in fileConfig
+func (file fileConfig) values() []configValue {
+	values := []configValue{}
+	add := func(name, value, path string) {
+		if value != "" {
+			values = append(values, configValue{name, value, path})
+		}
+	}
+	addBool := func(name string, value *bool, path string) {
+		if value != nil {
+			add(name, strconv.FormatBool(*value), path)
+		}
+	}
+	addInt := func(name string, value *int, path string) {
+		if value != nil {
+			add(name, strconv.Itoa(*value), path)
+		}
+	}
+	add("source", file.Source.Type, "source.type")
+	add("driver", file.Source.Driver, "source.driver")
+	add("dsn", file.Source.DSN, "source.dsn")
+	add("db", file.Source.DB, "source.db")
+	if file.Source.Customers != nil {
+		// an empty customers file is meaningful so it is set even when empty
+		values = append(values, configValue{"customers", *file.Source.Customers, "source.customers"})
+	}
+	add("orders", file.Source.Orders, "source.orders")
+	addBool("import", file.Source.Import, "source.import")
+	addBool("incremental", file.Source.Incremental, "source.incremental")
+	addInt("batchSize", file.Source.BatchSize, "source.batchSize")
+	add("onError", file.Source.OnError, "source.onError")
+	add("customersTable", file.Source.Tables.Customers, "source.tables.customers")
+	add("customerIdColumn", file.Source.Tables.CustomerID, "source.tables.customer_id")
+	add("customerCreatedColumn", file.Source.Tables.CustomerCreated, "source.tables.customer_created")
+	add("ordersTable", file.Source.Tables.Orders, "source.tables.orders")
+	add("orderCustomerColumn", file.Source.Tables.OrderCustomer, "source.tables.order_customer")
+	add("orderCreatedColumn", file.Source.Tables.OrderCreated, "source.tables.order_created")
+	if file.Source.Tables.OrderAmount != nil {
+		// an empty amount column is meaningful so it is set even when empty
+		values = append(values, configValue{"orderAmountColumn", *file.Source.Tables.OrderAmount, "source.tables.order_amount"})
+	}
+	add("orderCurrencyColumn", file.Source.Tables.OrderCurrency, "source.tables.order_currency")
+	add("datetimeLayout", strings.Join(file.Timestamps.Layouts, "|"), "timestamps.layouts")
+	add("customerDatetimeLayout", strings.Join(file.Timestamps.Customers, "|"), "timestamps.customers")
+	add("orderDatetimeLayout", strings.Join(file.Timestamps.Orders, "|"), "timestamps.orders")
+	addBool("detectTimestamps", file.Timestamps.Detect, "timestamps.detect")
+	add("timezone", file.Timezone, "timezone")
+	add("cohortPeriod", file.CohortPeriod, "cohortPeriod")
+	add("cohortBy", file.CohortBy, "cohortBy")
+	add("segmentBy", file.SegmentBy, "segmentBy")
+	add("bucketPeriod", file.BucketPeriod, "bucketPeriod")
+	add("buckets", cohort.Buckets(file.Buckets).String(), "buckets")
+	add("retentionMode", file.RetentionMode, "retentionMode")
+	add("metrics", strings.Join(file.Metrics, ","), "metrics")
+	addBool("revenue", file.Revenue, "revenue")
+	add("engine", file.Engine, "engine")
+	addInt("workers", file.Workers, "workers")
+	add("from", file.Filters.From, "filters.from")
+	add("to", file.Filters.To, "filters.to")
+	add("observedUntil", file.Filters.ObservedUntil, "filters.observedUntil")
+	add("customerFilter", file.Filters.Customers, "filters.customers")
+	add("orderFilter", file.Filters.Orders, "filters.orders")
+	add("output", file.Output.Path, "output.path")
+	addBool("stdout", file.Output.Stdout, "output.stdout")
+	add("format", file.Output.Format, "output.format")
+	add("rejects", file.Output.Rejects, "output.rejects")
+	add("addr", file.Addr, "addr")
+	return values
+}
+
+// fileColumns holds the csv column mapping of the loaded config file, nil if it kept the default mapping
+var fileColumns *cohort.ColumnMapping
+
+// readConfigFile parses the yaml config file at path and returns it along with the line of every key
+func readConfigFile(path string) (fileConfig, map[string]int, error) {
+	file := fileConfig{Columns: cohort.DefaultColumnMapping()}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return file, nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return file, nil, err
+	}
+	return file, keyLines(data), nil
+}
+
+// applyConfigFile sets every flag not passed on the command line to the value of the config file at path
+func applyConfigFile(path string) error {
+	file, _, err := readConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read config %s with error %s", path, err.Error())
+	}
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	for _, value := range file.values() {
+		if passed[value.flag] {
+			continue
+		}
+		if err := flag.Set(value.flag, value.value); err != nil {
+			return fmt.Errorf("Invalid %s in config %s with error %s", value.path, path, err.Error())
+		}
+	}
+	if file.Columns != cohort.DefaultColumnMapping() && !passed["columns"] {
+		fileColumns = &file.Columns
+	}
+	return nil
+}
+
+// configValidators check the values of flags that are only parsed once a run starts
+var configValidators = map[string]func(string) error{
+	"source": func(value string) error {
+		if value != "csv" && value != "db" {
+			return fmt.Errorf("Unsupported source %q expected one of csv or db", value)
+		}
+		return nil
+	},
+	"driver": func(value string) error {
+		_, err := cohort.NewDialect(value)
+		return err
+	},
+	"timezone": func(value string) error {
+		_, err := time.LoadLocation(value)
+		return err
+	},
+	"cohortPeriod": func(value string) error {
+		_, err := cohort.ParsePeriod(value)
+		return err
+	},
+	"cohortBy": func(value string) error {
+		_, err := cohort.ParseCohortBy(value)
+		return err
+	},
+	"segmentBy": func(value string) error {
+		_, err := cohort.ParseSegmentBy(value)
+		return err
+	},
+	"bucketPeriod": func(value string) error {
+		_, err := cohort.ParsePeriod(value)
+		return err
+	},
+	"buckets": func(value string) error {
+		_, err := cohort.ParseBuckets(value)
+		return err
+	},
+	"retentionMode": func(value string) error {
+		_, err := cohort.ParseRetentionMode(value)
+		return err
+	},
+	"metrics": func(value string) error {
+		_, err := cohort.ParseMetrics(value)
+		return err
+	},
+	"engine": func(value string) error {
+		_, err := cohort.ParseEngine(value)
+		return err
+	},
+	"onError": func(value string) error {
+		_, err := cohort.ParseErrorMode(value)
+		return err
+	},
+	"workers":       positive,
+	"batchSize":     positive,
+	"from":          parseDate,
+	"to":            parseDate,
+	"observedUntil": parseDate,
+	"customerFilter": func(value string) error {
+		_, err := cohort.ParseFilter(value)
+		return err
+	},
+	"orderFilter": func(value string) error {
+		_, err := cohort.ParseFilter(value)
+		return err
+	},
+	"format": func(value string) error {
+		_, err := cohort.NewExporter(value)
+		return err
+	},
+}
+
+func positive(value string) error {
+	if number, err := strconv.Atoi(value); err != nil || number < 1 {
+		return fmt.Errorf("Invalid value %q expected a positive number", value)
+	}
+	return nil
+}
+
+func parseDate(value string) error {
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		return fmt.Errorf("Invalid date %q expected YYYY-MM-DD", value)
+	}
+	return nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// validateConfigFile reports every error of the config file at configPath prefixed by its path and line number, valid values are set on their flags
+func validateConfigFile(configPath string) []error {
+	file, lines, err := readConfigFile(configPath)
+	if err != nil {
+		// yaml reports a line per error of a type error and a single line for syntax errors
+		messages := []string{err.Error()}
+		if typeError, ok := err.(*yaml.TypeError); ok {
+			messages = typeError.Errors
+		}
+		errs := []error{}
+		for _, message := range messages {
+			if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+				errs = append(errs, fmt.Errorf("%s:%s: %s", configPath, match[1], match[2]))
+			} else {
+				errs = append(errs, fmt.Errorf("%s: %s", configPath, message))
+			}
+		}
+		return errs
+	}
+	errs := []error{}
+	report := func(path string, err error) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", configPath, keyLine(lines, path), err.Error()))
+	}
+	for _, value := range file.values() {
+		if err := flag.Set(value.flag, value.value); err != nil {
+			report(value.path, err)
+			continue
+		}
+		if validate, ok := configValidators[value.flag]; ok {
+			if err := validate(value.value); err != nil {
+				report(value.path, err)
+			}
+		}
+	}
+	if err := file.Columns.Validate(); err != nil {
+		report("columns", err)
+	}
+	if *source == "db" {
+		schema := makeSchema()
+		if err := schema.Validate(); err != nil {
+			report("source.tables", err)
+		}
+		if *incremental && schema != cohort.DefaultSchema() {
+			report("source.incremental", errors.New("Incremental cohorts require the default schema"))
+		}
+	}
+	return errs
+}
+
+// keyLine returns the line of the key at path or of its closest parent found in lines, 0 if none was found
+func keyLine(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		if i := strings.LastIndex(path, "."); i != -1 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return 0
+}
+
+// keyLines maps the dotted path of every key of a block style yaml document to its line number
+func keyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	type key struct {
+		indent int
+		name   string
+	}
+	parents := []key{}
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon == -1 {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		for len(parents) != 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		parents = append(parents, key{indent, strings.Trim(trimmed[:colon], `"'`)})
+		names := make([]string, len(parents))
+		for j, parent := range parents {
+			names[j] = parent.name
+		}
+		lines[strings.Join(names, ".")] = i + 1
+	}
+	return lines
+}
+
+// validateConfig runs the validate-config subcommand, printing every error of the config file named by -config or the first argument
+func validateConfig(args []string) int {
+	flag.CommandLine.Parse(args)
+	path := *configPath
+	if path == "" && flag.NArg() != 0 {
+		path = flag.Arg(0)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "validate-config expects a config file")
+		return 2
+	}
+	errs := validateConfigFile(path)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+	fmt.Println(path, "is valid")
+	return 0
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"projects/cohort-analysis/cohort"
+)
+
+// writeTestConfig writes a yaml config file and returns a function restoring the flags it may set along with removing it
+func writeTestConfig(t *testing.T, content string) (string, func()) {
+	tmp, err := ioutil.TempFile("", "test-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return tmp.Name(), func() {
+		for name, value := range values {
+			flag.Set(name, value)
+		}
+		fileColumns = nil
+		os.Remove(tmp.Name())
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path, cleanup := writeTestConfig(t, `source:
+  type: csv
+columns:
+  signup_at: signed_up
+cohortPeriod: month
+bucketPeriod: day
+buckets: [0, 1, 7, 30]
+metrics:
+  - orderers
+  - ltv
+filters:
+  from: 2015-01-01
+`)
+	defer cleanup()
+	flag.Set("bucketPeriod", "week")
+	assert.NoError(t, applyConfigFile(path), "should apply config files")
+	config, err := makeConfig()
+	assert.NoError(t, err, "should make configs from config files")
+	assert.Equal(t, "month", string(config.CohortPeriod), "should set flags from config files")
+	assert.Equal(t, "week", string(config.BucketPeriod), "should keep flags passed on the command line")
+	assert.Equal(t, []string{"orderers", "ltv"}, config.Metrics, "should join metric lists")
+	assert.Equal(t, cohort.Buckets{0, 1, 7, 30}, config.Buckets, "should join bucket lists")
+	assert.Equal(t, "signed_up", config.Columns.SignupAt, "should map columns from config files")
+	assert.Equal(t, "id", config.Columns.CustomerID, "should keep default headers of unmapped columns")
+	assert.Equal(t, 2015, config.From.Year(), "should filter cohorts from config files")
+	assert.Empty(t, validateConfigFile(path), "should not report errors of valid config files")
+}
+
+func TestValidateConfig(t *testing.T) {
+	path, cleanup := writeTestConfig(t, `source:
+  type: db
+  tables:
+    orders: "orders; DROP TABLE customers"
+cohortPeriod: fortnight
+metrics: [orderers, churn]
+workers: 0
+filters:
+  observedUntil: yesterday
+  orders: "amount > 10; DROP TABLE orders"
+output:
+  format: xml
+`)
+	defer cleanup()
+	errs := []string{}
+	for _, err := range validateConfigFile(path) {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		path + `:5: Unsupported period "fortnight" expected one of day, week, month, quarter or year`,
+		path + `:6: Unknown metric "churn" expected one of [aov cumulative_retention first_time ltv nrr order_count orderers orders_per_customer repeat_rate]`,
+		path + `:7: Invalid value "0" expected a positive number`,
+		path + `:9: Invalid date "yesterday" expected YYYY-MM-DD`,
+		path + `:10: Invalid filter "amount > 10; DROP TABLE orders" unexpected character ';' at 11`,
+		path + `:12: Unknown format "xml" expected one of [csv html json svg tidy]`,
+		path + `:3: Invalid orders table "orders; DROP TABLE customers" expected a table name`,
+	}, errs, "should report every invalid value along with its line")
+
+	path, cleanup = writeTestConfig(t, "source:\n  kind: csv\nworkers: many\n")
+	defer cleanup()
+	errs = []string{}
+	for _, err := range validateConfigFile(path) {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		path + ":2: field kind not found in type main.sourceConfig",
+		path + ":3: cannot unmarshal !!str `many` into int",
+	}, errs, "should report yaml errors along with their line")
+}
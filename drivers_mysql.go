@@ -0,0 +1,7 @@
+//go:build mysql
+// +build mysql
+
+package main
+
+// registers the mysql driver for -driver mysql, build with -tags mysql
+import _ "github.com/go-sql-driver/mysql"
@@ -0,0 +1,38 @@
+//go:build mysql
+// +build mysql
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"projects/cohort-analysis/cohort"
+)
+
+// TestMySQLSkipImport runs against the database named by COHORT_MYSQL_DSN such as "user:pass@tcp(localhost:3306)/cohorts_test", its tables are dropped
+func TestMySQLSkipImport(t *testing.T) {
+	dsn := os.Getenv("COHORT_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("COHORT_MYSQL_DSN is not set")
+	}
+	db, err := cohort.Connect("mysql", dsn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := cohort.MakeTables(db); err != nil {
+		t.Fatal(err)
+	}
+
+	customers := "id,created\n33559,2015-06-19 23:49:32\nabc,2015-06-19 23:49:32\n33559,2015-06-21 10:00:00\n33600,2015-06-27 10:00:00\n"
+	config := cohort.DefaultConfig()
+	config.OnError = cohort.SkipErrors
+	report, err := cohort.ImportCustomersFrom(db, strings.NewReader(customers), "customers.csv", config)
+	assert.NoError(t, err, "should skip rejected rows with mysql")
+	assert.Equal(t, 2, report.Accepted, "should count the rows written to mysql")
+	assert.Equal(t, 2, report.Rejected, "should reject invalid rows and rows refused by mysql")
+}
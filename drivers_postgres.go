@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package main
+
+// registers the postgres driver for -driver postgres, build with -tags postgres
+import _ "github.com/lib/pq"
@@ -2,7 +2,10 @@ module projects/cohort-analysis
 
 require (
 	github.com/ZeFort/chance v0.0.0-20150129172704-bd0104b650ee
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/huandu/go-sqlbuilder v1.4.2
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/stretchr/testify v1.4.0
+	gopkg.in/yaml.v2 v2.2.2
 )
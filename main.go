@@ -1,445 +1,334 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
+
+	"projects/cohort-analysis/cohort"
+	"projects/cohort-analysis/server"
 )
 
 var (
+	configPath     = flag.String("config", "", "specify a yaml file describing the run, flags passed on the command line override its values")
 	dbname         = flag.String("db", "./cohort-analysis.db", "specify the db file in which SQL data should be stored")
-	customerCSV    = flag.String("customers", "./data/customers.csv", "specify the path to the customer data")
+	driver         = flag.String("driver", "sqlite3", "specify the database driver data is stored with (sqlite3, postgres or mysql)")
+	dsn            = flag.String("dsn", "", "specify the data source name passed to the database driver, defaults to the db file")
+	customerCSV    = flag.String("customers", "./data/customers.csv", "specify the path to the customer data, empty to import orders alone")
 	orderCSV       = flag.String("orders", "./data/orders.csv", "specify the path to the order data")
-	datetimeLayout = flag.String("datetimeLayout", "2006-01-02 15:04:05 UTC", "specify the layout of datetime")
-	timezone       = flag.String("timezone", "UTC", "specify the timezone the UTC defined datetimes should be stored in")
+	datetimeLayout = flag.String("datetimeLayout", cohort.DefaultDatetimeLayout, "specify the layouts of imported datetimes separated by |, datetimes without an offset are read as UTC")
+	customerLayout = flag.String("customerDatetimeLayout", "", "specify the layouts of imported customer datetimes separated by |, defaults to -datetimeLayout")
+	orderLayout    = flag.String("orderDatetimeLayout", "", "specify the layouts of imported order datetimes separated by |, defaults to -datetimeLayout")
+	detectLayouts  = flag.Bool("detectTimestamps", true, "specify that RFC3339 datetimes, epoch seconds and milliseconds and dates should be detected when no layout matches")
+	timezone       = flag.String("timezone", "UTC", "specify the reporting timezone cohort windows and order buckets are computed in, datetimes are always stored as UTC")
 	runImport      = flag.String("import", "true", "specify if import of data should run")
 	outputPath     = flag.String("output", "./results.csv", "specify the file path for the results output")
-	stdoutMode     = flag.Bool("stdout", false, "specify that the output csv should be written to stdout")
+	stdoutMode     = flag.Bool("stdout", false, "specify that the output should be written to stdout")
+	addr           = flag.String("addr", ":8080", "specify the address the serve subcommand listens on")
+	format         = flag.String("format", "csv", "specify the format of the results output (csv, json, tidy, html or svg)")
+	cohortPeriod   = flag.String("cohortPeriod", "week", "specify the period customers are grouped into cohorts by (day, week, month, quarter or year)")
+	cohortBy       = flag.String("cohortBy", "signup", "specify whether customers join cohorts at their signup or at their first order, first order cohorts only hold buyers and need no customers file (signup or first_order)")
+	segmentBy      = flag.String("segmentBy", "", "specify a customers column cohorts are computed separately for every value of, such as an imported country or channel column")
+	bucketPeriod   = flag.String("bucketPeriod", "week", "specify the period orders are bucketed by from customer creation (day, week, month, quarter or year)")
+	buckets        = flag.String("buckets", "", "specify comma separated days from customer creation orders are bucketed by in place of -bucketPeriod, the last bucket is open ended (such as 0,1,7,14,30,60,90)")
+	revenue        = flag.Bool("revenue", false, "specify that lifetime value, average order value and net revenue retention rows should be output")
+	retentionMode  = flag.String("retentionMode", "bracket", "specify which buckets customers count as retained in (bracket, unbounded or cumulative)")
+	metrics        = flag.String("metrics", "orderers,first_time", "specify a comma separated list of metrics output as a row per cohort")
+	engine         = flag.String("engine", "memory", "specify whether orders are aggregated in memory or by the database (memory or sql)")
+	workers        = flag.Int("workers", 1, "specify the number of cohorts computed concurrently")
+	batchSize      = flag.Int("batchSize", 100, "specify the number of imported rows written per insert statement within the import transaction")
+	incremental    = flag.Bool("incremental", false, "specify that imports should upsert new rows into the existing db and reuse unchanged cohorts")
+	from           = flag.String("from", "", "specify the YYYY-MM-DD date in the timezone cohorts start from, defaults to the earliest customer")
+	to             = flag.String("to", "", "specify the YYYY-MM-DD date in the timezone cohorts start until, defaults to the latest customer")
+	observedUntil  = flag.String("observedUntil", "", "specify the YYYY-MM-DD date in the timezone the data was extracted on, buckets no customer had reached by it are censored, defaults to the latest order or customer")
+	customerFilter = flag.String("customerFilter", "", "specify a filter expression customers are restricted to such as \"country = 'US' and created >= '2015-01-01'\", datetimes are read in the timezone")
+	orderFilter    = flag.String("orderFilter", "", "specify a filter expression orders are restricted to such as \"amount > 10\"")
+	onError        = flag.String("onError", "fail", "specify how imports handle invalid rows, fail rolls back the import at the first one, skip leaves them out and quarantine also stores them in the rejects table (fail, skip or quarantine)")
+	rejectsPath    = flag.String("rejects", "./rejects.csv", "specify the file path rejected rows are written to when an import rejects any")
+	columns        = flag.String("columns", "", "specify a yaml file mapping the fields of imported csv files to their header names")
+	source         = flag.String("source", "csv", "specify whether cohorts are computed from imported csv files or read directly from the tables of an existing db (csv or db)")
+	customersTable = flag.String("customersTable", "customers", "specify the table customers are read from with -source db")
+	customerID     = flag.String("customerIdColumn", "id", "specify the customer id column read with -source db")
+	customerTime   = flag.String("customerCreatedColumn", "created", "specify the customer signup timestamp column read with -source db")
+	ordersTable    = flag.String("ordersTable", "orders", "specify the table orders are read from with -source db")
+	orderCustomer  = flag.String("orderCustomerColumn", "user_id", "specify the order customer id column read with -source db")
+	orderTime      = flag.String("orderCreatedColumn", "created", "specify the order timestamp column read with -source db")
+	orderAmount    = flag.String("orderAmountColumn", "amount", "specify the order amount column read with -source db, empty if orders have no amount")
+	orderCurrency  = flag.String("orderCurrencyColumn", "", "specify the order currency column read with -source db, revenue metrics fail on cohorts mixing currencies when it is set")
 )
 
-var customerSchema = map[string]string{
-	"id":      "int not null primary key",
-	"created": "datetime not null",
-}
-
-var orderSchema = map[string]string{
-	"id":           "int not null primary key",
-	"order_number": "int not null",
-	"user_id":      "int not null",
-	"created":      "datetime not null",
-}
-
-func makeTables() (SQL, error) {
-	shouldImport := true
-	if *runImport == "false" {
-		shouldImport = false
+func makeTables() (cohort.SQL, error) {
+	// incremental imports keep existing data instead of rebuilding the db and existing databases are only ever read
+	shouldDrop := true
+	if *runImport == "false" || *incremental || *source == "db" {
+		shouldDrop = false
+	}
+	dataSource := *dsn
+	if dataSource == "" {
+		dataSource = *dbname
 	}
-	db, err := ConnectDB(shouldImport, *dbname)
+	db, err := cohort.Connect(*driver, dataSource, shouldDrop)
 	if err != nil {
 		return db, fmt.Errorf("Failed to connect to database with error %s", err.Error())
 	}
-
-	if err := CreateTable(db, "customers", customerSchema); err != nil {
-		return db, fmt.Errorf("Failed to create customers table with error %s", err.Error())
+	if *source == "db" {
+		return db, nil
 	}
 
-	if err := CreateTable(db, "orders", orderSchema); err != nil {
-		return db, fmt.Errorf("Failed to create orders table with error %s", err.Error())
+	if err := cohort.MakeTables(db); err != nil {
+		return db, err
 	}
 
 	return db, nil
 }
 
-func makeCustomerImportTransformer(timezone *time.Location) func([]string, []string) map[string]interface{} {
-	return func(_, line []string) map[string]interface{} {
-		customer := make(map[string]interface{})
-		customer["id"], _ = strconv.Atoi(line[0])
-
-		created := line[1]
-		if datetime, err := time.Parse(*datetimeLayout, fmt.Sprintf("%s UTC", created)); err != nil {
-			log.Println("failed to parse date", err)
-			customer["created"] = ""
-		} else {
-			customer["created"] = datetime.In(timezone).Format("2006-01-02T15:04:05")
-		}
-		return customer
+// makeSchema maps the tables and columns read with -source db
+func makeSchema() cohort.Schema {
+	return cohort.Schema{
+		CustomersTable:  *customersTable,
+		CustomerID:      *customerID,
+		CustomerCreated: *customerTime,
+		OrdersTable:     *ordersTable,
+		OrderCustomer:   *orderCustomer,
+		OrderCreated:    *orderTime,
+		OrderAmount:     *orderAmount,
+		OrderCurrency:   *orderCurrency,
 	}
 }
 
-func makeOrderImportTransformer(timezone *time.Location) func([]string, []string) map[string]interface{} {
-	return func(_, line []string) map[string]interface{} {
-		order := make(map[string]interface{})
-		order["id"], _ = strconv.Atoi(line[0])
-		order["order_number"], _ = strconv.Atoi(line[1])
-		order["user_id"], _ = strconv.Atoi(line[2])
-
-		created := line[3]
-		if datetime, err := time.Parse(*datetimeLayout, fmt.Sprintf("%s UTC", created)); err != nil {
-			log.Println("failed to parse date", err)
-			order["created"] = ""
-		} else {
-			order["created"] = datetime.In(timezone).Format("2006-01-02T15:04:05")
+// splitLayouts returns the | separated datetime layouts of override or of layouts if override is empty
+func splitLayouts(layouts, override string) []string {
+	if override != "" {
+		layouts = override
+	}
+	split := []string{}
+	for _, layout := range strings.Split(layouts, "|") {
+		if layout != "" {
+			split = append(split, layout)
 		}
-		return order
 	}
+	return split
 }
 
-func importCustomers(db SQL, timezone *time.Location) error {
-	customerTransformer := makeCustomerImportTransformer(timezone)
-	if importer, ok, err := NewImporter().Open(*customerCSV); !ok {
-		return err
-	} else {
-		hasSkipped := false
-		for {
-			if value, err := importer.Read(customerTransformer); err != nil {
-				if err == io.EOF {
-					break
-				} else if _, ok := err.(MismatchError); ok {
-					if !hasSkipped {
-						hasSkipped = true
-						continue
-					} else {
-						break
-					}
-				} else {
-					return err
-				}
-			} else {
-				hasSkipped = false
-				if err := Insert(db, "customers", []interface{}{value["id"], value["created"]}); err != nil {
-					return err
-				}
-			}
+func makeConfig() (cohort.Config, error) {
+	config := cohort.DefaultConfig()
+	config.CustomerTimestamps = cohort.NewTimestampParser(*detectLayouts, splitLayouts(*datetimeLayout, *customerLayout)...)
+	config.OrderTimestamps = cohort.NewTimestampParser(*detectLayouts, splitLayouts(*datetimeLayout, *orderLayout)...)
+	config.Incremental = *incremental
+	config.BatchSize = *batchSize
+	config.Workers = *workers
+	// parse metrics along with periods used for cohort windows and order buckets
+	var err error
+	if config.Metrics, err = cohort.ParseMetrics(*metrics); err != nil {
+		return config, err
+	}
+	if *revenue {
+		config.Metrics = append(config.Metrics, "ltv", "aov", "nrr")
+	}
+	if config.CohortPeriod, err = cohort.ParsePeriod(*cohortPeriod); err != nil {
+		return config, err
+	}
+	if config.CohortBy, err = cohort.ParseCohortBy(*cohortBy); err != nil {
+		return config, err
+	}
+	if config.BucketPeriod, err = cohort.ParsePeriod(*bucketPeriod); err != nil {
+		return config, err
+	}
+	if *buckets != "" {
+		if config.Buckets, err = cohort.ParseBuckets(*buckets); err != nil {
+			return config, err
 		}
 	}
-	return nil
-}
-
-func importOrders(db SQL, timezone *time.Location) error {
-	orderTransformer := makeOrderImportTransformer(timezone)
-	if importer, ok, err := NewImporter().Open(*orderCSV); !ok {
-		return err
-	} else {
-		hasSkipped := false
-		for {
-			if value, err := importer.Read(orderTransformer); err != nil {
-				if err == io.EOF {
-					break
-				} else if _, ok := err.(MismatchError); ok {
-					if !hasSkipped {
-						hasSkipped = true
-						continue
-					} else {
-						break
-					}
-				} else {
-					return err
-				}
-			} else {
-				hasSkipped = false
-				if err := Insert(db, "orders", []interface{}{value["id"], value["order_number"], value["user_id"], value["created"]}); err != nil {
-					return err
-				}
-			}
+	if *segmentBy != "" {
+		if config.SegmentBy, err = cohort.ParseSegmentBy(*segmentBy); err != nil {
+			return config, err
 		}
 	}
-	return nil
-}
-
-func getStartDate(db SQL) (*time.Time, error) {
-	var startDate time.Time
-
-	if rows, err := Query(db, "customers", []string{"*"}, QueryOptions{
-		Limit:   1,
-		OrderBy: "created",
-		Asc:     true,
-	}); err != nil {
-		return nil, err
-	} else {
-		var id string
-		var created string
-		if rows.Next() {
-			if err := rows.Scan(&id, &created); err != nil {
-				return nil, err
-			}
+	if config.RetentionMode, err = cohort.ParseRetentionMode(*retentionMode); err != nil {
+		return config, err
+	}
+	if config.Engine, err = cohort.ParseEngine(*engine); err != nil {
+		return config, err
+	}
+	if config.OnError, err = cohort.ParseErrorMode(*onError); err != nil {
+		return config, err
+	}
+	if *columns != "" {
+		if config.Columns, err = cohort.LoadColumnMapping(*columns); err != nil {
+			return config, err
+		}
+	} else if fileColumns != nil {
+		config.Columns = *fileColumns
+		if err := config.Columns.Validate(); err != nil {
+			return config, err
 		}
-		rows.Close()
-		startDate, _ = time.Parse("2006-01-02T15:04:05Z", created)
 	}
-	return &startDate, nil
-}
-
-func getEndDate(db SQL) (*time.Time, error) {
-	var endDate time.Time
-
-	if rows, err := Query(db, "customers", []string{"*"}, QueryOptions{
-		Limit:   1,
-		OrderBy: "created",
-		Asc:     false,
-	}); err != nil {
-		return nil, err
-	} else {
-		var id string
-		var created string
-		if rows.Next() {
-			if err := rows.Scan(&id, &created); err != nil {
-				return nil, err
-			}
+	switch *source {
+	case "csv":
+	case "db":
+		config.Schema = makeSchema()
+		if err := config.Schema.Validate(); err != nil {
+			return config, err
 		}
-		rows.Close()
-		endDate, _ = time.Parse("2006-01-02T15:04:05Z", created)
+	default:
+		return config, fmt.Errorf("Unsupported source %q expected one of csv or db", *source)
 	}
-	return &endDate, nil
-}
-
-type Orders struct {
-	UniqueOrders    map[string]bool
-	FirstTimeOrders int
-}
-
-type Cohort struct {
-	Dates             string
-	MaxDaysFromCreate int
-	Customers         map[string]time.Time
-	HasOrder          map[string]bool
-	Orders            map[int]Orders
-}
-
-func aggregateOrders(db SQL, query string, customers map[string]time.Time, hasOrder map[string]bool) (map[int]Orders, int, error) {
-	aggregatedOrders := map[int]Orders{}
-	// query orders in ascending date order to ensure that first time orders are tied to earliest order date
-	orders, err := Query(db, "orders", []string{"user_id", "created"}, QueryOptions{
-		OrderBy: "created",
-		Asc:     true,
-		Where:   query,
-	})
-	if err != nil {
-		return nil, 0, err
-	}
-	defer orders.Close()
-	var (
-		userID  string
-		created string
-	)
-	maxDays := 0
-	for orders.Next() {
-		err := orders.Scan(&userID, &created)
-		if err != nil {
-			return nil, 0, err
+	// load custom timezone data
+	if config.Location, err = time.LoadLocation(*timezone); err != nil {
+		log.Println("failed to load timezone", err)
+		config.Location = time.UTC
+	}
+	// limit cohorts to those starting between the given dates
+	if *from != "" {
+		if config.From, err = time.ParseInLocation("2006-01-02", *from, config.Location); err != nil {
+			return config, fmt.Errorf("Invalid from date %s", err.Error())
 		}
-		// check if customer for given order exists in customer creation datetime map
-		if customerCreateDate, ok := customers[userID]; ok {
-			orderCreateDate, _ := time.Parse("2006-01-02T15:04:05Z", created)
-			// get the number of days from customer creation the order was placed
-			daysSinceCustomerCreate := int(orderCreateDate.Sub(customerCreateDate).Hours() / float64(24))
-			// create an order for given number of days if it does not alrady exist
-			if _, ok := aggregatedOrders[daysSinceCustomerCreate]; !ok {
-				aggregatedOrders[daysSinceCustomerCreate] = Orders{
-					make(map[string]bool),
-					0,
-				}
-			}
-			// track max days from customer creation
-			if maxDays < daysSinceCustomerCreate {
-				maxDays = daysSinceCustomerCreate
-			}
-			// check if order was already placed by that customer that day
-			aggregatedOrders[daysSinceCustomerCreate].UniqueOrders[userID] = true
-			if _, ok := hasOrder[userID]; !ok {
-				hasOrder[userID] = true
-				order := aggregatedOrders[daysSinceCustomerCreate]
-				// increment first time orders count if customer had not ever placed an order
-				order.FirstTimeOrders++
-				aggregatedOrders[daysSinceCustomerCreate] = order
-			}
+	}
+	if *to != "" {
+		if config.To, err = time.ParseInLocation("2006-01-02", *to, config.Location); err != nil {
+			return config, fmt.Errorf("Invalid to date %s", err.Error())
 		}
 	}
-	return aggregatedOrders, maxDays, nil
-}
-
-func generateCohort(db SQL, customers *sql.Rows, dates string) (Cohort, error) {
-	var id string
-	var created string
-	cohort := Cohort{
-		dates,
-		0,
-		make(map[string]time.Time),
-		make(map[string]bool),
-		make(map[int]Orders),
-	}
-	// create query for orders table based on customer ids
-	orderWhereQuery := strings.Builder{}
-	orderWhereQuery.WriteString("user_id IN (")
-	isFirst := true
-	defer customers.Close()
-	for customers.Next() {
-		err := customers.Scan(&id, &created)
-		if err != nil {
-			return cohort, err
+	if *observedUntil != "" {
+		if config.ObservedUntil, err = time.ParseInLocation("2006-01-02", *observedUntil, config.Location); err != nil {
+			return config, fmt.Errorf("Invalid observedUntil date %s", err.Error())
 		}
-		createdDate, _ := time.Parse("2006-01-02T15:04:05Z", created)
-		cohort.Customers[id] = createdDate
-		if isFirst {
-			orderWhereQuery.WriteString(id)
-		} else {
-			orderWhereQuery.WriteString(fmt.Sprintf(", %s", id))
+	}
+	if *customerFilter != "" {
+		if config.CustomerFilter, err = cohort.ParseFilter(*customerFilter); err != nil {
+			return config, err
 		}
-		isFirst = false
 	}
-	orderWhereQuery.WriteString(")")
-	// query for orders that come from specified customers and aggregate on days from sign up date
-	aggregatedOrders, maxDays, err := aggregateOrders(db, orderWhereQuery.String(), cohort.Customers, cohort.HasOrder)
-	if err != nil {
-		return cohort, err
+	if *orderFilter != "" {
+		if config.OrderFilter, err = cohort.ParseFilter(*orderFilter); err != nil {
+			return config, err
+		}
 	}
-	cohort.Orders = aggregatedOrders
-	cohort.MaxDaysFromCreate = maxDays
-	return cohort, nil
+	return config, nil
 }
 
-func makeCohortRows(cohort Cohort, headers *OrderedStringSet) [][]string {
-	// set default header values
-	headers.Add("Cohort").Add("Customers")
-	uniqueOrders := []string{cohort.Dates, fmt.Sprintf("%d customers", len(cohort.Customers))}
-	firstTimeOrders := []string{"", ""}
-	start := 0
-	end := 6
-	for {
-		// iteratively go through 7 day ranges until day exceeds max number of days for order from customer creation
-		if start > cohort.MaxDaysFromCreate {
-			break
-		}
-		// set unique day ranges to headers
-		headers.Add(fmt.Sprintf("%d-%d", start, end))
-		uniqueCount := 0
-		firstOrderCount := 0
-		// iterate through map of orders by day for each day in range aggregating unique orders and first time orders
-		for days := start; days <= end; days++ {
-			if orders, ok := cohort.Orders[days]; ok {
-				uniqueCount += len(orders.UniqueOrders)
-				firstOrderCount += orders.FirstTimeOrders
-			}
-		}
-		// format unique order count for csv row
-		if uniqueCount == 0 {
-			uniqueOrders = append(uniqueOrders, "0% orderers (0)")
-		} else {
-			uniqueOrders = append(uniqueOrders, fmt.Sprintf(
-				"%.2f%% orderers (%d)",
-				(float64(uniqueCount)/float64(len(cohort.Customers)))*100,
-				uniqueCount,
-			))
-		}
-		// format first time order count for csv row
-		if firstOrderCount == 0 {
-			firstTimeOrders = append(firstTimeOrders, "0% 1st time (0)")
-		} else {
-			firstTimeOrders = append(firstTimeOrders, fmt.Sprintf(
-				"%.2f%% 1st time (%d)",
-				(float64(firstOrderCount)/float64(len(cohort.Customers)))*100,
-				firstOrderCount,
-			))
+// rejectsFile creates the rejects file on the first write so runs without rejected rows leave it alone
+type rejectsFile struct {
+	path string
+	file *os.File
+}
+
+func (rejects *rejectsFile) Write(data []byte) (int, error) {
+	if rejects.file == nil {
+		var err error
+		if rejects.file, err = os.Create(rejects.path); err != nil {
+			return 0, err
 		}
-		start += 7
-		end += 7
 	}
-	return [][]string{
-		uniqueOrders,
-		firstTimeOrders,
+	return rejects.file.Write(data)
+}
+
+// Close closes the rejects file if it was created
+func (rejects *rejectsFile) Close() error {
+	if rejects.file == nil {
+		return nil
 	}
+	return rejects.file.Close()
 }
 
-func writeCohortRows(output io.Writer, cohort [][]string) error {
-	if exporter, ok, err := NewExporter().Open(output); ok {
-		err := exporter.Write(cohort[0])
-		if err != nil {
-			return err
+// importData imports the customers and orders csv files, logs a summary of every import and streams any rejected rows to the rejects file
+func importData(db cohort.SQL, config cohort.Config) error {
+	reports := []cohort.ImportReport{}
+	var importErr error
+	rejects := &rejectsFile{path: *rejectsPath}
+	if *rejectsPath != "" {
+		config.Rejects = cohort.NewRejectWriter(rejects)
+	}
+	defer rejects.Close()
+	for _, file := range []struct {
+		name     string
+		path     string
+		importer func(cohort.SQL, string, cohort.Config) (cohort.ImportReport, error)
+	}{
+		{"customers", *customerCSV, cohort.ImportCustomers},
+		{"orders", *orderCSV, cohort.ImportOrders},
+	} {
+		// first order cohorts can be computed from orders alone
+		if file.path == "" {
+			log.Println("skipping", file.name, "without a file")
+			continue
 		}
-		for i := len(cohort) - 1; i > 0; i -= 2 {
-			if err := exporter.Write(cohort[i-1]); err != nil {
-				return err
-			}
-			if err := exporter.Write(cohort[i]); err != nil {
-				return err
-			}
+		log.Println("importing", file.name)
+		report, err := file.importer(db, file.path, config)
+		reports = append(reports, report)
+		if err != nil {
+			importErr = err
+			break
 		}
-	} else {
+	}
+	// every collected report is summarized, including the one of a failed import
+	rejected := 0
+	for _, report := range reports {
+		log.Println(report)
+		rejected += report.Rejected
+	}
+	if rejected == 0 || config.Rejects == nil {
+		return importErr
+	}
+	if err := config.Rejects.Flush(); err != nil {
 		return err
 	}
-	return nil
+	log.Println("wrote", rejected, "rejected rows to", *rejectsPath)
+	return importErr
 }
 
 func main() {
-	flag.Parse()
+	// the validate-config subcommand checks a config file without running
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+	// the serve subcommand keeps the database open and answers cohort queries over http
+	serveMode := len(os.Args) > 1 && os.Args[1] == "serve"
+	if serveMode {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	if *configPath != "" {
+		if err := applyConfigFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	config, err := makeConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 	// create tables necessary for storing customer and order data
 	db, err := makeTables()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
-	// load custom timezone data
-	tz, err := time.LoadLocation(*timezone)
-	if err != nil {
-		log.Println("failed to load timezone", err)
-		tz, _ = time.LoadLocation("UTC")
-	}
-	// import data from csvs and load data to sqlite instance
-	if *runImport != "false" {
-		log.Println("importing customers")
-		if err := importCustomers(db, tz); err != nil {
-			log.Fatal(err)
-		}
-		log.Println("importing orders")
-		if err := importOrders(db, tz); err != nil {
+	// import data from csvs and load data to sqlite instance, existing databases are read as they are
+	if *runImport != "false" && *source == "csv" {
+		if err := importData(db, config); err != nil {
 			log.Fatal(err)
 		}
 	}
-	// query customer table for earliest customer creation date
-	startDate, err := getStartDate(db)
-	if err != nil {
-		log.Fatal(err)
+	if serveMode {
+		log.Println("listening on", *addr)
+		log.Fatal(http.ListenAndServe(*addr, server.New(db, config)))
 	}
-	// query customer table for latest customer creation date
-	endDate, err := getEndDate(db)
+	exporter, err := cohort.NewExporter(*format)
 	if err != nil {
 		log.Fatal(err)
 	}
-	var cohortsRows [][]string
-	headers := NewOrderedStringSet()
 	log.Println("aggregating data")
-	for {
-		// iteratively query customer data set in intervals of seven days until date range exceeds latest customer creation date
-		year, month, day := startDate.Date()
-		gte := time.Date(year, month, day, 0, 0, 0, 0, tz)
-		lt := gte.Add(time.Duration(7*24) * time.Hour)
-		if rows, err := Query(db, "customers", []string{"id", "created"}, QueryOptions{
-			OrderBy: "created",
-			Asc:     true,
-			Where:   fmt.Sprintf("created BETWEEN \"%s\" AND \"%s\"", gte.Format("2006-01-02T15:04:05Z"), lt.Format("2006-01-02T15:04:05Z")),
-		}); err != nil {
-			log.Fatal(err)
-		} else {
-			// generate cohort data from customers returned from query
-			cohort, err := generateCohort(db, rows, fmt.Sprintf("%s-%s", gte.Format("01/02/2006"), gte.Add(time.Duration(6*24)*time.Hour).Format("01/02/2006")))
-			if err != nil {
-				log.Fatal(err)
-			}
-			// convert cohort struct data to rows comforming to expected format
-			cohortsRows = append(cohortsRows, makeCohortRows(cohort, &headers)...)
-		}
-		startDate = &lt
-		if startDate.After(*endDate) {
-			break
-		}
+	result, err := cohort.Compute(context.Background(), db, config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	// append header row to cohort data rows
-	cohortsRows = append([][]string{headers.Values()}, cohortsRows...)
 	var output io.Writer
 	// optionally set output to stdout or to target output file
 	if *stdoutMode {
@@ -448,10 +337,13 @@ func main() {
 		if outputFile, err := os.Create(*outputPath); err != nil {
 			log.Fatal(err)
 		} else {
+			defer outputFile.Close()
 			output = outputFile
 		}
 	}
-	// write cohort csv data to target
-	writeCohortRows(output, cohortsRows)
+	// write cohort data to target in the selected format
+	if err := exporter.Export(output, result); err != nil {
+		log.Fatal(err)
+	}
 	log.Println("done")
 }
@@ -0,0 +1,222 @@
+// Package server exposes cohort computation and data imports over HTTP
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"projects/cohort-analysis/cohort"
+)
+
+// contentTypes maps export formats to the content type of their responses
+var contentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"tidy": "text/csv; charset=utf-8",
+	"json": "application/json",
+	"html": "text/html; charset=utf-8",
+	"svg":  "image/svg+xml",
+}
+
+// maxUploadMemory is the amount of an uploaded csv kept in memory before spilling to temporary files
+const maxUploadMemory = 32 << 20
+
+// Server answers cohort queries and imports against a single open database
+type Server struct {
+	db     cohort.SQL
+	config cohort.Config
+	// lock prevents cohorts from being computed while an import is writing to the database
+	lock sync.RWMutex
+	mux  *http.ServeMux
+}
+
+// New creates a Server for db, config provides defaults for parameters missing from requests
+func New(db cohort.SQL, config cohort.Config) *Server {
+	server := &Server{db: db, config: config, mux: http.NewServeMux()}
+	server.mux.HandleFunc("/cohorts", server.handleCohorts)
+	server.mux.HandleFunc("/import", server.handleImport)
+	return server
+}
+
+// ServeHTTP routes requests to the cohort and import handlers
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// parseDate parses a date in the server timezone, an empty value returns the zero time
+func (server *Server) parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, server.config.Location)
+}
+
+// makeConfig overrides the server config with the query parameters of a cohort request
+func (server *Server) makeConfig(r *http.Request) (cohort.Config, error) {
+	config := server.config
+	query := r.URL.Query()
+	var err error
+	if value := query.Get("period"); value != "" {
+		if config.CohortPeriod, err = cohort.ParsePeriod(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("cohortBy"); value != "" {
+		if config.CohortBy, err = cohort.ParseCohortBy(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("segmentBy"); value != "" {
+		if config.SegmentBy, err = cohort.ParseSegmentBy(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("bucketPeriod"); value != "" {
+		if config.BucketPeriod, err = cohort.ParsePeriod(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("buckets"); value != "" {
+		if config.Buckets, err = cohort.ParseBuckets(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("retentionMode"); value != "" {
+		if config.RetentionMode, err = cohort.ParseRetentionMode(value); err != nil {
+			return config, err
+		}
+	}
+	// metrics may be repeated or comma separated
+	if values := query["metric"]; len(values) != 0 {
+		if config.Metrics, err = cohort.ParseMetrics(strings.Join(values, ",")); err != nil {
+			return config, err
+		}
+	}
+	if config.From, err = server.parseDate(query.Get("from")); err != nil {
+		return config, fmt.Errorf("Invalid from date %s", err.Error())
+	}
+	if config.To, err = server.parseDate(query.Get("to")); err != nil {
+		return config, fmt.Errorf("Invalid to date %s", err.Error())
+	}
+	if value := query.Get("observedUntil"); value != "" {
+		if config.ObservedUntil, err = server.parseDate(value); err != nil {
+			return config, fmt.Errorf("Invalid observedUntil date %s", err.Error())
+		}
+	}
+	if value := query.Get("customerFilter"); value != "" {
+		if config.CustomerFilter, err = cohort.ParseFilter(value); err != nil {
+			return config, err
+		}
+	}
+	if value := query.Get("orderFilter"); value != "" {
+		if config.OrderFilter, err = cohort.ParseFilter(value); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// handleCohorts computes cohorts for GET /cohorts and writes them in the requested format
+func (server *Server) handleCohorts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config, err := server.makeConfig(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	exporter, err := cohort.NewExporter(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server.lock.RLock()
+	result, err := cohort.Compute(r.Context(), server.db, config)
+	server.lock.RUnlock()
+	if err != nil {
+		log.Println("failed to compute cohorts", err)
+		http.Error(w, "failed to compute cohorts", http.StatusInternalServerError)
+		return
+	}
+	if contentType, ok := contentTypes[format]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if err := exporter.Export(w, result); err != nil {
+		log.Println("failed to export cohorts", err)
+	}
+}
+
+// importFile opens an uploaded file and imports it into the database
+func (server *Server) importFile(header *multipart.FileHeader, importer func(cohort.SQL, io.Reader, string, cohort.Config) (cohort.ImportReport, error)) (cohort.ImportReport, error) {
+	file, err := header.Open()
+	if err != nil {
+		return cohort.ImportReport{File: header.Filename}, err
+	}
+	defer file.Close()
+	return importer(server.db, file, header.Filename, server.config)
+}
+
+// handleImport imports the customers and orders csv files uploaded as multipart form files for POST /import and responds with a report of the rows accepted and rejected per file
+func (server *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// imports write to the default tables which a mapped schema does not read from
+	if server.config.Schema != cohort.DefaultSchema() {
+		http.Error(w, "imports are disabled when reading a mapped schema", http.StatusConflict)
+		return
+	}
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	customers, hasCustomers := r.MultipartForm.File["customers"]
+	orders, hasOrders := r.MultipartForm.File["orders"]
+	if !hasCustomers && !hasOrders {
+		http.Error(w, "expected a customers or orders file", http.StatusBadRequest)
+		return
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	reports := []cohort.ImportReport{}
+	// customers are imported ahead of orders so uploads containing both stay consistent
+	for _, header := range customers {
+		report, err := server.importFile(header, cohort.ImportCustomersFrom)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to import customers %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		reports = append(reports, report)
+	}
+	for _, header := range orders {
+		report, err := server.importFile(header, cohort.ImportOrdersFrom)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to import orders %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		reports = append(reports, report)
+	}
+	for _, report := range reports {
+		log.Println(report)
+	}
+	w.Header().Set("Content-Type", contentTypes["json"])
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		log.Println("failed to write import reports", err)
+	}
+}
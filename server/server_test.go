@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"projects/cohort-analysis/cohort"
+)
+
+func makeTestServer(t *testing.T) (*Server, func()) {
+	tmp, err := ioutil.TempFile("", "test-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	db, err := cohort.ConnectDB(true, tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cohort.MakeTables(db); err != nil {
+		t.Fatal(err)
+	}
+	config := cohort.DefaultConfig()
+	config.ObservedUntil = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	return New(db, config), func() {
+		db.Close()
+		os.Remove(tmp.Name())
+	}
+}
+
+// makeImportRequest creates a multipart POST /import request uploading files keyed by form field
+func makeImportRequest(files map[string]string) *http.Request {
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+	for field, content := range files {
+		part, _ := writer.CreateFormFile(field, field+".csv")
+		part.Write([]byte(content))
+	}
+	writer.Close()
+	request := httptest.NewRequest(http.MethodPost, "/import", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestServer(t *testing.T) {
+	server, cleanup := makeTestServer(t)
+	defer cleanup()
+
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, makeImportRequest(map[string]string{
+		"customers": "id,created\n33559,2015-06-19 23:49:32\n33563,2015-06-20 00:09:03\n33600,2015-07-10 10:00:00\n",
+		"orders":    "id,order_number,user_id,created\n26444,1,33563,2015-06-25 01:27:40\n",
+	}))
+	assert.Equal(t, http.StatusOK, response.Code, "should import uploaded customers and orders")
+	reports := []cohort.ImportReport{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &reports), "should report imports as json")
+	assert.Equal(t, 2, len(reports), "should report every uploaded file")
+	assert.Equal(t, "customers.csv", reports[0].File, "should report customers ahead of orders")
+	assert.Equal(t, 3, reports[0].Accepted, "should report accepted rows")
+
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, makeImportRequest(map[string]string{
+		"orders": "id,order_number,user_id,created\n26445,1,abc,2015-06-25 01:27:40\n",
+	}))
+	assert.Equal(t, http.StatusBadRequest, response.Code, "should reject imports of invalid rows")
+	assert.Contains(t, response.Body.String(), "Rejected line 2 of orders.csv", "should identify rejected rows")
+
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, makeImportRequest(map[string]string{}))
+	assert.Equal(t, http.StatusBadRequest, response.Code, "should reject imports without files")
+
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/cohorts?period=week&to=2015-06-25&metric=orderers", nil))
+	assert.Equal(t, http.StatusOK, response.Code, "should compute cohorts")
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"), "should default to json")
+	structured := struct {
+		Cohorts []struct {
+			Start     string `json:"start"`
+			Customers int    `json:"customers"`
+			Buckets   []struct {
+				Metrics []struct {
+					Metric string  `json:"metric"`
+					Rate   float64 `json:"rate"`
+				} `json:"metrics"`
+			} `json:"buckets"`
+		} `json:"cohorts"`
+	}{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &structured), "should write valid json")
+	assert.Equal(t, 1, len(structured.Cohorts), "should only include cohorts starting before to date")
+	assert.Equal(t, 2, structured.Cohorts[0].Customers, "should count imported customers")
+	assert.Equal(t, "orderers", structured.Cohorts[0].Buckets[0].Metrics[0].Metric, "should only include requested metrics")
+	assert.Equal(t, 0.5, structured.Cohorts[0].Buckets[0].Metrics[0].Rate, "should include imported orders")
+	assert.Equal(t, 1, len(structured.Cohorts[0].Buckets[0].Metrics), "should only include requested metrics")
+
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/cohorts?from=2015-07-01&format=csv", nil))
+	assert.Equal(t, http.StatusOK, response.Code, "should compute cohorts as csv")
+	assert.Equal(t, "text/csv; charset=utf-8", response.Header().Get("Content-Type"), "should set csv content type")
+	assert.Equal(t, "Cohort,Customers,0-6\n07/08/2015-07/14/2015,1 customers,0% orderers (0)\n,,0% 1st time (0)\n07/01/2015-07/07/2015,0 customers,0% orderers (0)\n,,0% 1st time (0)\n", response.Body.String(), "should only include cohorts starting after from date")
+
+	for _, query := range []string{"period=fortnight", "metric=churn", "from=yesterday", "format=xml", "segmentBy=country%3B", "customerFilter=country+%3D+%27US%27%3B+DROP+TABLE+customers"} {
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/cohorts?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, response.Code, "should reject invalid parameter "+query)
+	}
+
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/cohorts", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code, "should only allow GET for cohorts")
+}